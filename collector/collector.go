@@ -15,6 +15,7 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -45,17 +46,33 @@ var (
 )
 
 const (
-	defaultEnabled = true
-	//defaultDisabled = false
+	defaultEnabled  = true
+	defaultDisabled = false
 )
 
 var (
-	factories        = make(map[string]func(logger log.Logger) (Collector, error))
+	factories        = make(map[string]func(logger log.Logger, session *Session, config *Config) (Collector, error))
 	collectorState   = make(map[string]*bool)
 	forcedCollectors = map[string]bool{} // collectors which have been explicitly enabled or disabled
+	// defaultProperties are the optional properties of each collector.
+	defaultProperties = make(map[string][]string)
+	// optionTypes create the specific options of each collector.
+	optionTypes = make(map[string]func() collectorOptions)
 )
 
-func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger) (Collector, error)) {
+// registerProperties declares the optional properties retrieved by
+// collector, which the configuration file can restrict.
+func registerProperties(collector string, properties []string) {
+	defaultProperties[collector] = properties
+}
+
+// registerOptions declares the specific options of collector, which the
+// configuration file decodes into the value returned by newOptions.
+func registerOptions(collector string, newOptions func() collectorOptions) {
+	optionTypes[collector] = newOptions
+}
+
+func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger, session *Session, config *Config) (Collector, error)) {
 	var helpDefaultState string
 	if isDefaultEnabled {
 		helpDefaultState = "enabled"
@@ -76,6 +93,7 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func(log
 // MainCollector implements the prometheus.Collector interface.
 type MainCollector struct {
 	Collectors map[string]Collector
+	session    *Session
 	logger     log.Logger
 }
 
@@ -101,8 +119,9 @@ func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error
 	}
 }
 
-// NewMainCollector creates a new MainCollector.
-func NewMainCollector(logger log.Logger, filters ...string) (*MainCollector, error) {
+// NewMainCollector creates a new MainCollector scraping the vc of the given
+// session, with the collector options of config.
+func NewMainCollector(logger log.Logger, session *Session, config *Config, filters ...string) (*MainCollector, error) {
 	f := make(map[string]bool)
 	for _, filter := range filters {
 		enabled, exist := collectorState[filter]
@@ -117,7 +136,7 @@ func NewMainCollector(logger log.Logger, filters ...string) (*MainCollector, err
 	collectors := make(map[string]Collector)
 	for key, enabled := range collectorState {
 		if *enabled {
-			collector, err := factories[key](log.With(logger, "collector", key))
+			collector, err := factories[key](log.With(logger, "collector", key), session, config)
 			if err != nil {
 				return nil, err
 			}
@@ -126,31 +145,75 @@ func NewMainCollector(logger log.Logger, filters ...string) (*MainCollector, err
 			}
 		}
 	}
-	return &MainCollector{Collectors: collectors, logger: logger}, nil
+	return &MainCollector{Collectors: collectors, session: session, logger: logger}, nil
+}
+
+// NewModuleCollector creates a new MainCollector running the collectors of a
+// probe module against the vc of the given session. Collectors listed by the
+// module are run even if disabled on the command line. A module without
+// collectors runs every enabled collector.
+func NewModuleCollector(logger log.Logger, session *Session, config *Config, module *Module) (*MainCollector, error) {
+	if len(module.Collectors) == 0 {
+		return NewMainCollector(logger, session, config)
+	}
+	collectors := make(map[string]Collector)
+	for _, key := range module.Collectors {
+		factory, exist := factories[key]
+		if !exist {
+			return nil, fmt.Errorf("missing collector: %s", key)
+		}
+		collector, err := factory(log.With(logger, "collector", key), session, config)
+		if err != nil {
+			return nil, err
+		}
+		collectors[key] = collector
+	}
+	return &MainCollector{Collectors: collectors, session: session, logger: logger}, nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (n MainCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- sessionAgeDesc
+	ch <- sessionReloginDesc
+	ch <- sessionLoginFailuresDesc
+	ch <- sessionTLSFailuresDesc
+	ch <- credentialsLoadTimeDesc
+	ch <- credentialsLoadSuccessDesc
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n MainCollector) Collect(ch chan<- prometheus.Metric) {
+	scrape, err := newScrape(context.Background(), n.session)
+	if err != nil {
+		level.Error(n.logger).Log("msg", "unable to connect", "err", err)
+		for name := range n.Collectors {
+			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, 0, name)
+			ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0, name)
+		}
+	} else {
+		n.collect(scrape, ch)
+	}
+	n.session.Collect(ch)
+}
+
+// collect runs every collector in parallel against the same scrape.
+func (n MainCollector) collect(scrape *Scrape, ch chan<- prometheus.Metric) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
 		go func(name string, c Collector) {
-			execute(name, c, ch, n.logger)
+			execute(name, c, scrape, ch, n.logger)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.Logger) {
+func execute(name string, c Collector, scrape *Scrape, ch chan<- prometheus.Metric, logger log.Logger) {
 	begin := time.Now()
-	err := c.Update(ch)
+	err := c.Update(scrape, ch)
 	duration := time.Since(begin)
 	var success float64
 
@@ -171,8 +234,8 @@ func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.L
 
 // Collector is the interface a collector has to implement.
 type Collector interface {
-	// Get new metrics and expose them via prometheus registry.
-	Update(ch chan<- prometheus.Metric) error
+	// Get new metrics from the given scrape and expose them via prometheus registry.
+	Update(scrape *Scrape, ch chan<- prometheus.Metric) error
 }
 
 type typedDesc struct {
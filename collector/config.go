@@ -0,0 +1,269 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	config_util "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the exporter configuration file.
+type Config struct {
+	// MetricsTarget names the target served on /metrics, in place of the
+	// collector.vc.* flags.
+	MetricsTarget string                     `yaml:"metrics_target"`
+	Targets       map[string]Target          `yaml:"targets"`
+	Modules       map[string]Module          `yaml:"modules"`
+	Collectors    map[string]CollectorConfig `yaml:"collectors"`
+	Labels        LabelsConfig               `yaml:"labels"`
+}
+
+// Target is a vc that can be scraped by name.
+type Target struct {
+	URL    string `yaml:"url"`
+	Module string `yaml:"module"`
+}
+
+// Module holds the credentials and the collectors used to probe a vc.
+type Module struct {
+	Username     string             `yaml:"username"`
+	Password     config_util.Secret `yaml:"password"`
+	UsernameFile string             `yaml:"username_file"`
+	PasswordFile string             `yaml:"password_file"`
+	Collectors   []string           `yaml:"collectors"`
+	TLSConfig    TLSConfig          `yaml:"tls_config"`
+}
+
+// CollectorConfig holds the options of a single collector.
+type CollectorConfig struct {
+	// Properties replaces the properties retrieved by the collector, the
+	// metrics of the other properties are reported with zero values.
+	Properties []string     `yaml:"properties"`
+	Filters    FilterConfig `yaml:"filters"`
+	// Options holds the specific options of the collector, of the type it
+	// registered with registerOptions.
+	Options collectorOptions `yaml:"-"`
+
+	// options holds the other keys until LoadConfig decodes them into
+	// Options.
+	options map[string]interface{}
+}
+
+// collectorOptions are the specific options of a collector.
+type collectorOptions interface {
+	validate() error
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CollectorConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var plain struct {
+		Properties []string               `yaml:"properties"`
+		Filters    FilterConfig           `yaml:"filters"`
+		Options    map[string]interface{} `yaml:",inline"`
+	}
+	if err := unmarshal(&plain); err != nil {
+		return err
+	}
+	c.Properties = plain.Properties
+	c.Filters = plain.Filters
+	c.options = plain.Options
+	return nil
+}
+
+// decodeOptions decodes the specific options of the collector into the
+// type it registered, rejecting the keys that type does not know.
+func (c *CollectorConfig) decodeOptions(collector string) error {
+	newOptions, ok := optionTypes[collector]
+	if !ok {
+		if len(c.options) > 0 {
+			keys := make([]string, 0, len(c.options))
+			for key := range c.options {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("unknown option %q", keys[0])
+		}
+		return nil
+	}
+	options := newOptions()
+	if len(c.options) > 0 {
+		content, err := yaml.Marshal(c.options)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(content, options); err != nil {
+			return err
+		}
+	}
+	if err := options.validate(); err != nil {
+		return err
+	}
+	c.Options = options
+	c.options = nil
+	return nil
+}
+
+// FilterConfig selects the objects exported by a collector by name. An
+// object is exported when it matches one of the include expressions, if
+// any, and none of the exclude expressions.
+type FilterConfig struct {
+	Include []Regexp `yaml:"include"`
+	Exclude []Regexp `yaml:"exclude"`
+}
+
+// Match reports whether the object name passes the filter.
+func (f FilterConfig) Match(name string) bool {
+	for _, re := range f.Exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, re := range f.Include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelsConfig holds the label options.
+type LabelsConfig struct {
+	// Intrinsec adds the labels parsed from the vm annotations, as
+	// --collector.intrinsec does.
+	Intrinsec bool `yaml:"intrinsec"`
+}
+
+// Regexp is a regular expression anchored at both ends.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return err
+	}
+	re.Regexp = r
+	re.original = s
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	return re.original, nil
+}
+
+// Credentials returns the credentials of the module.
+func (m *Module) Credentials() Credentials {
+	return Credentials{
+		Username:     m.Username,
+		Password:     string(m.Password),
+		UsernameFile: m.UsernameFile,
+		PasswordFile: m.PasswordFile,
+	}
+}
+
+// TargetSession returns the session and the module of the named target.
+func (c *Config) TargetSession(logger log.Logger, name string) (*Session, *Module, error) {
+	target, ok := c.Targets[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown target %q", name)
+	}
+	module := c.Modules[target.Module]
+	return GetSession(logger, target.URL, module.Credentials(), module.TLSConfig), &module, nil
+}
+
+// collectorConfig returns the options of the collector, the properties
+// default to the ones it registered and the specific options to their zero
+// value.
+func (c *Config) collectorConfig(collector string) CollectorConfig {
+	cc := c.Collectors[collector]
+	if len(cc.Properties) == 0 {
+		cc.Properties = defaultProperties[collector]
+	}
+	if newOptions, ok := optionTypes[collector]; ok && cc.Options == nil {
+		cc.Options = newOptions()
+	}
+	return cc
+}
+
+// LoadConfig reads and validates the configuration file.
+func LoadConfig(configPath string) (*Config, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = yaml.UnmarshalStrict(content, c)
+	if err != nil {
+		return nil, err
+	}
+	for name, module := range c.Modules {
+		if module.Username == "" && module.UsernameFile == "" {
+			return nil, fmt.Errorf("module %s: missing username or username_file", name)
+		}
+		if module.Password != "" && module.PasswordFile != "" {
+			return nil, fmt.Errorf("module %s: at most one of password and password_file must be set", name)
+		}
+		if _, err := module.TLSConfig.Build(); err != nil {
+			return nil, fmt.Errorf("module %s: %s", name, err)
+		}
+		for _, collector := range module.Collectors {
+			if _, exist := factories[collector]; !exist {
+				return nil, fmt.Errorf("module %s: missing collector: %s", name, collector)
+			}
+		}
+	}
+	for name, target := range c.Targets {
+		if target.URL == "" {
+			return nil, fmt.Errorf("target %s: missing url", name)
+		}
+		if _, ok := c.Modules[target.Module]; !ok {
+			return nil, fmt.Errorf("target %s: unknown module %q", name, target.Module)
+		}
+	}
+	if _, ok := c.Targets[c.MetricsTarget]; c.MetricsTarget != "" && !ok {
+		return nil, fmt.Errorf("metrics_target: unknown target %q", c.MetricsTarget)
+	}
+	for name, collector := range c.Collectors {
+		if _, exist := factories[name]; !exist {
+			return nil, fmt.Errorf("collectors: missing collector: %s", name)
+		}
+		for _, p := range collector.Properties {
+			if !containsString(defaultProperties[name], p) {
+				return nil, fmt.Errorf("collectors: %s: unsupported property %q", name, p)
+			}
+		}
+		if err := collector.decodeOptions(name); err != nil {
+			return nil, fmt.Errorf("collectors: %s: %s", name, err)
+		}
+		c.Collectors[name] = collector
+	}
+	return c, nil
+}
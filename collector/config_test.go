@@ -0,0 +1,69 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		path          string
+		expectedError *regexp.Regexp
+	}{
+		{"testdata/config.good.yml", nil},
+		{"testdata/missing.yml", regexp.MustCompile(`no such file`)},
+		{"testdata/config_junk_key.bad.yml", regexp.MustCompile(`field passwd not found`)},
+		{"testdata/config_target_module.bad.yml", regexp.MustCompile(`unknown module "missing"`)},
+		{"testdata/config_metrics_target.bad.yml", regexp.MustCompile(`metrics_target: unknown target "lyon"`)},
+		{"testdata/config_property.bad.yml", regexp.MustCompile(`unsupported property "summary"`)},
+		{"testdata/config_collector.bad.yml", regexp.MustCompile(`missing collector: cpu`)},
+		{"testdata/config_filter.bad.yml", regexp.MustCompile(`missing closing \)`)},
+		{"testdata/config_perf_kind.bad.yml", regexp.MustCompile(`unsupported counters kind "cluster"`)},
+		{"testdata/config_perf_option.bad.yml", regexp.MustCompile(`field batch_size not found`)},
+		{"testdata/config_perf_unknown.bad.yml", regexp.MustCompile(`field batchsize not found`)},
+		{"testdata/config_perf_repeated.bad.yml", regexp.MustCompile(`counter "net.received.average" repeated for esx`)},
+		{"testdata/config_mounts.bad.yml", regexp.MustCompile(`unknown option "mounts"`)},
+	}
+	for _, test := range tests {
+		_, err := LoadConfig(test.path)
+		switch {
+		case test.expectedError == nil && err != nil:
+			t.Errorf("%s: unexpected error %s", test.path, err)
+		case test.expectedError != nil && err == nil:
+			t.Errorf("%s: expected error matching %s", test.path, test.expectedError)
+		case test.expectedError != nil && !test.expectedError.MatchString(err.Error()):
+			t.Errorf("%s: error %q does not match %s", test.path, err, test.expectedError)
+		}
+	}
+}
+
+func TestFilterConfig(t *testing.T) {
+	c, err := LoadConfig("testdata/config.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := c.Collectors["vm"].Filters
+	for name, want := range map[string]bool{
+		"prod-web":          true,
+		"prod-web-template": false,
+		"dev-web":           false,
+		"xprod-web":         false,
+	} {
+		if have := filter.Match(name); have != want {
+			t.Errorf("%s: want match %v, have %v", name, want, have)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	vcUsernameFile = kingpin.Flag("collector.vc.username-file", "File containing the vc api username, re-read when it changes").Envar("VC_USERNAME_FILE").Default("").String()
+	vcPasswordFile = kingpin.Flag("collector.vc.password-file", "File containing the vc api password, re-read when it changes").Envar("VC_PASSWORD_FILE").Default("").String()
+)
+
+var (
+	credentialsLoadTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "credentials_last_load_timestamp_seconds"),
+		"govc_exporter: Timestamp of the last load of the vc api credential files.",
+		[]string{"vc"},
+		nil,
+	)
+	credentialsLoadSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "credentials_load_success"),
+		"govc_exporter: Whether the last load of the vc api credential files succeeded.",
+		[]string{"vc"},
+		nil,
+	)
+)
+
+// Credentials are the vc api username and password. The files, when set,
+// take precedence over the values.
+type Credentials struct {
+	Username     string
+	Password     string
+	UsernameFile string
+	PasswordFile string
+}
+
+func defaultCredentials() Credentials {
+	return Credentials{
+		Username:     *vcUsername,
+		Password:     *vcPassword,
+		UsernameFile: *vcUsernameFile,
+		PasswordFile: *vcPasswordFile,
+	}
+}
+
+func (c Credentials) hasFiles() bool {
+	return c.UsernameFile != "" || c.PasswordFile != ""
+}
+
+// credentialsLoader keeps the current username and password, reloading the
+// credential files when their modification time changes.
+type credentialsLoader struct {
+	credentials Credentials
+	username    string
+	password    string
+
+	modTimes    map[string]time.Time
+	lastLoad    time.Time
+	lastSuccess bool
+}
+
+func newCredentialsLoader(c Credentials) *credentialsLoader {
+	return &credentialsLoader{
+		credentials: c,
+		username:    c.Username,
+		password:    c.Password,
+		modTimes:    make(map[string]time.Time),
+	}
+}
+
+// load re-reads the modified credential files and reports whether the
+// username or password changed.
+func (l *credentialsLoader) load() (bool, error) {
+	if !l.credentials.hasFiles() {
+		return false, nil
+	}
+	username, usernameRead, err := l.read(l.credentials.UsernameFile, l.username)
+	if err != nil {
+		l.fail()
+		return false, err
+	}
+	password, passwordRead, err := l.read(l.credentials.PasswordFile, l.password)
+	if err != nil {
+		l.fail()
+		return false, err
+	}
+	if usernameRead || passwordRead || !l.lastSuccess {
+		l.lastLoad = time.Now()
+		l.lastSuccess = true
+	}
+	changed := username != l.username || password != l.password
+	l.username = username
+	l.password = password
+	return changed, nil
+}
+
+func (l *credentialsLoader) fail() {
+	l.lastLoad = time.Now()
+	l.lastSuccess = false
+}
+
+// read returns the content of file and whether it has been read, or current
+// if file is unset or unchanged since the previous read.
+func (l *credentialsLoader) read(file string, current string) (string, bool, error) {
+	if file == "" {
+		return current, false, nil
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return current, false, err
+	}
+	if modTime, ok := l.modTimes[file]; ok && modTime.Equal(st.ModTime()) {
+		return current, false, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return current, false, err
+	}
+	l.modTimes[file] = st.ModTime()
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+func (l *credentialsLoader) collect(ch chan<- prometheus.Metric, vc string) {
+	if !l.credentials.hasFiles() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(credentialsLoadTimeDesc, prometheus.GaugeValue, float64(l.lastLoad.Unix()), vc)
+	ch <- prometheus.MustNewConstMetric(credentialsLoadSuccessDesc, prometheus.GaugeValue, b2f(l.lastSuccess), vc)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentialsLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newCredentialsLoader(Credentials{Username: "user", PasswordFile: passwordFile})
+	changed, err := l.load()
+	if err != nil || !changed {
+		t.Fatalf("first load: want changed, have changed=%v err=%v", changed, err)
+	}
+	if l.username != "user" || l.password != "secret1" {
+		t.Errorf("unexpected credentials %s/%s", l.username, l.password)
+	}
+	if changed, _ := l.load(); changed {
+		t.Errorf("unchanged file reported as changed")
+	}
+
+	if err := ioutil.WriteFile(passwordFile, []byte("secret2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(passwordFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = l.load()
+	if err != nil || !changed || l.password != "secret2" {
+		t.Errorf("rotated password not reloaded: changed=%v err=%v password=%s", changed, err, l.password)
+	}
+
+	os.Remove(passwordFile)
+	if _, err := l.load(); err == nil || l.lastSuccess {
+		t.Errorf("missing file not reported as a failed load")
+	}
+	if l.password != "secret2" {
+		t.Errorf("failed load dropped the previous password")
+	}
+}
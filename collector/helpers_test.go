@@ -0,0 +1,135 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+)
+
+// checkDescLabels fails unless names are the variable labels of desc, in
+// order: each name is given as the value of the label at its position.
+func checkDescLabels(t *testing.T, desc typedDesc, names ...string) {
+	t.Helper()
+	m, err := prometheus.NewConstMetric(desc.desc, desc.valueType, 0, names...)
+	if err != nil {
+		t.Errorf("%s: %s", desc.desc, err)
+		return
+	}
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range pb.Label {
+		if label.GetName() != label.GetValue() {
+			t.Errorf("%s: want label %s at the position of %s", desc.desc, label.GetValue(), label.GetName())
+		}
+	}
+}
+
+// simulatorSession starts vcsim with its vc model and returns a session on
+// it, and a function stopping both.
+func simulatorSession(t *testing.T) (*Session, func()) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	server := model.Service.NewServer()
+	u := *server.URL
+	u.User = nil
+	s := newSession(log.NewNopLogger(), u.String(), Credentials{Username: "user", Password: "pass"}, TLSConfig{InsecureSkipVerify: true})
+	return s, func() {
+		s.Close(context.Background())
+		server.Close()
+		model.Remove()
+	}
+}
+
+// eventually fails unless cond returns true within a few seconds.
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// collectSimulator runs the Update of c against vcsim, once modified by
+// setup, and returns the values of the metrics by series, e.g.
+// govc_esx_network_pnic_link_up{esx="DC0_H0",...}.
+func collectSimulator(t *testing.T, c Collector, setup ...func(ctx context.Context, vc *vim25.Client)) map[string]float64 {
+	var res map[string]float64
+	simulator.Test(func(ctx context.Context, vc *vim25.Client) {
+		for _, f := range setup {
+			f(ctx, vc)
+		}
+		res = collectScrape(t, c, simulatorScrape(t, ctx, vc))
+	})
+	return res
+}
+
+// simulatorScrape returns a scrape of the vcsim server of vc.
+func simulatorScrape(t *testing.T, ctx context.Context, vc *vim25.Client) *Scrape {
+	hierarchy, err := retrieveHierarchy(ctx, vc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Scrape{ctx: ctx, client: &govmomi.Client{Client: vc}, hierarchy: hierarchy}
+}
+
+// collectScrape runs the Update of c and returns the values of the metrics
+// by series.
+func collectScrape(t *testing.T, c Collector, scrape *Scrape) map[string]float64 {
+	res := make(map[string]float64)
+	ch := make(chan prometheus.Metric)
+	var err error
+	go func() {
+		err = c.Update(scrape, ch)
+		close(ch)
+	}()
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		res[seriesName(m.Desc(), &pb)] = pb.GetGauge().GetValue() + pb.GetCounter().GetValue()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// seriesName returns the name of a metric followed by its labels, sorted by
+// name as written by the metric.
+func seriesName(desc *prometheus.Desc, pb *dto.Metric) string {
+	labels := make([]string, 0, len(pb.Label))
+	for _, label := range pb.Label {
+		labels = append(labels, label.GetName()+"=\""+label.GetValue()+"\"")
+	}
+	name := strings.TrimPrefix(desc.String(), "Desc{fqName: \"")
+	name = name[:strings.Index(name, "\"")]
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
@@ -0,0 +1,453 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	useIncrementalInventory = kingpin.Flag("collector.inventory.incremental", "Maintain the inventory from vc property updates instead of retrieving it on every scrape").Default("false").Bool()
+	inventoryResync         = kingpin.Flag("collector.inventory.resync-interval", "Interval between full resynchronizations of the incremental inventory").Default("1h").Duration()
+	inventoryMaxStaleness   = kingpin.Flag("collector.inventory.max-staleness", "Retrieve the objects from vc on scrape when the incremental inventory has not been updated for this long").Default("5m").Duration()
+)
+
+const (
+	inventoryRetryWait = 10 * time.Second
+)
+
+var (
+	// inventoryMaxWait bounds each WaitForUpdatesEx call, so the lag
+	// metric stays meaningful when nothing changes.
+	inventoryMaxWait int32 = 60
+)
+
+var (
+	inventoryVersionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "inventory", "update_version"),
+		"govc_exporter: Version of the last property update applied to the inventory.",
+		[]string{"vc", "kind"},
+		nil,
+	)
+	inventoryLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "inventory", "lag_seconds"),
+		"govc_exporter: Time since the last property update response from vc.",
+		[]string{"vc", "kind"},
+		nil,
+	)
+	inventoryObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "inventory", "objects"),
+		"govc_exporter: Number of objects held by the inventory.",
+		[]string{"vc", "kind"},
+		nil,
+	)
+	inventoryResyncDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "inventory", "last_resync_timestamp_seconds"),
+		"govc_exporter: Timestamp of the last full resynchronization of the inventory.",
+		[]string{"vc", "kind"},
+		nil,
+	)
+	inventorySynchronizedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "inventory", "synchronized"),
+		"govc_exporter: Whether the inventory is synchronized and recently updated, the objects being retrieved from vc otherwise.",
+		[]string{"vc", "kind"},
+		nil,
+	)
+)
+
+// Inventory keeps the properties of the vc objects in memory, applying the
+// updates reported by a long-lived property filter.
+type Inventory struct {
+	session  *Session
+	logger   log.Logger
+	mux      sync.Mutex
+	watchers map[string]*inventoryWatcher
+	stop     chan struct{}
+}
+
+// inventoryWatcher follows the objects of a single kind.
+type inventoryWatcher struct {
+	inventory *Inventory
+	kind      string
+
+	mux sync.RWMutex
+	ps  []string
+	// objects is nil until the first full synchronization completes.
+	objects map[types.ManagedObjectReference]*types.ObjectContent
+	version string
+	// lastUpdate is the time of the last property update response, the
+	// objects are stale when it gets older than the max staleness.
+	lastUpdate time.Time
+	lastResync time.Time
+	cancel     context.CancelFunc
+}
+
+func newInventory(session *Session) *Inventory {
+	return &Inventory{
+		session:  session,
+		logger:   log.With(session.logger, "component", "inventory"),
+		watchers: make(map[string]*inventoryWatcher),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Retrieve loads the objects of the given kind into dst. It returns false
+// when the inventory does not hold these properties yet, the caller must
+// then retrieve them from vc.
+func (i *Inventory) Retrieve(kind string, ps []string, dst interface{}) (bool, error) {
+	i.mux.Lock()
+	w, ok := i.watchers[kind]
+	if !ok {
+		w = &inventoryWatcher{inventory: i, kind: kind, ps: append([]string(nil), ps...)}
+		sort.Strings(w.ps)
+		i.watchers[kind] = w
+		go w.loop()
+	}
+	i.mux.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	content, ok := w.content(ps)
+	if !ok {
+		return false, nil
+	}
+	return true, mo.LoadObjectContent(content, dst)
+}
+
+// Stop ends every watcher of the inventory.
+func (i *Inventory) Stop() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	close(i.stop)
+	for _, w := range i.watchers {
+		w.mux.Lock()
+		if w.cancel != nil {
+			w.cancel()
+		}
+		w.mux.Unlock()
+	}
+}
+
+// Collect exposes the inventory metrics.
+func (i *Inventory) Collect(ch chan<- prometheus.Metric) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	for kind, w := range i.watchers {
+		w.mux.RLock()
+		version, _ := strconv.ParseFloat(w.version, 64)
+		ch <- prometheus.MustNewConstMetric(inventoryVersionDesc, prometheus.GaugeValue, version, i.session.url, kind)
+		if !w.lastUpdate.IsZero() {
+			ch <- prometheus.MustNewConstMetric(inventoryLagDesc, prometheus.GaugeValue, time.Since(w.lastUpdate).Seconds(), i.session.url, kind)
+		}
+		ch <- prometheus.MustNewConstMetric(inventoryObjectsDesc, prometheus.GaugeValue, float64(len(w.objects)), i.session.url, kind)
+		if !w.lastResync.IsZero() {
+			ch <- prometheus.MustNewConstMetric(inventoryResyncDesc, prometheus.GaugeValue, float64(w.lastResync.Unix()), i.session.url, kind)
+		}
+		ch <- prometheus.MustNewConstMetric(inventorySynchronizedDesc, prometheus.GaugeValue, b2f(!w.stale()), i.session.url, kind)
+		w.mux.RUnlock()
+	}
+}
+
+// stale reports whether the objects are not synchronized yet, or were not
+// updated for longer than the max staleness, e.g. when WaitForUpdatesEx
+// keeps failing. w.mux must be held.
+func (w *inventoryWatcher) stale() bool {
+	return w.objects == nil || time.Since(w.lastUpdate) > *inventoryMaxStaleness
+}
+
+// content returns the synchronized objects if they hold every requested
+// property and are not stale. Otherwise the missing properties are added to
+// the filter, which triggers a full resynchronization.
+func (w *inventoryWatcher) content(ps []string) ([]types.ObjectContent, bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	missing := false
+	for _, p := range ps {
+		if !containsString(w.ps, p) {
+			w.ps = append(w.ps, p)
+			missing = true
+		}
+	}
+	if missing {
+		sort.Strings(w.ps)
+		w.objects = nil
+		if w.cancel != nil {
+			w.cancel()
+		}
+		return nil, false
+	}
+	if w.stale() {
+		return nil, false
+	}
+	res := make([]types.ObjectContent, 0, len(w.objects))
+	for _, o := range w.objects {
+		res = append(res, *o)
+	}
+	return res, true
+}
+
+func (w *inventoryWatcher) loop() {
+	logger := log.With(w.inventory.logger, "kind", w.kind)
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		w.mux.Lock()
+		w.cancel = cancel
+		ps := append([]string(nil), w.ps...)
+		w.mux.Unlock()
+
+		select {
+		case <-w.inventory.stop:
+			cancel()
+			return
+		default:
+		}
+
+		level.Debug(logger).Log("msg", "starting inventory synchronization", "properties", strings.Join(ps, ","))
+		err := w.run(ctx, ps)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			level.Error(logger).Log("msg", "inventory update error", "err", err)
+			select {
+			case <-w.inventory.stop:
+				return
+			case <-time.After(inventoryRetryWait):
+			}
+		}
+	}
+}
+
+// run creates a property filter on every object of the watched kind and
+// applies the updates until ctx is canceled or a resync is due.
+func (w *inventoryWatcher) run(ctx context.Context, ps []string) error {
+	client, err := w.inventory.session.Client(ctx)
+	if err != nil {
+		return err
+	}
+	pc, err := property.DefaultCollector(client.Client).Create(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = pc.Destroy(context.Background())
+	}()
+
+	m := view.NewManager(client.Client)
+	v, err := m.CreateContainerView(ctx, client.ServiceContent.RootFolder, []string{w.kind}, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = v.Destroy(context.Background())
+	}()
+
+	err = pc.CreateFilter(ctx, types.CreateFilter{
+		Spec: types.PropertyFilterSpec{
+			ObjectSet: []types.ObjectSpec{{
+				Obj:  v.Reference(),
+				Skip: types.NewBool(true),
+				SelectSet: []types.BaseSelectionSpec{
+					&types.TraversalSpec{
+						Type: v.Reference().Type,
+						Path: "view",
+					},
+				},
+			}},
+			PropSet: []types.PropertySpec{{
+				Type:    w.kind,
+				PathSet: ps,
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := types.WaitForUpdatesEx{
+		This: pc.Reference(),
+		Options: &types.WaitOptions{
+			MaxWaitSeconds: types.NewInt32(inventoryMaxWait),
+		},
+	}
+	objects := make(map[types.ManagedObjectReference]*types.ObjectContent)
+	synced := false
+	resync := time.After(*inventoryResync)
+	for {
+		select {
+		case <-resync:
+			return nil
+		default:
+		}
+
+		res, err := methods.WaitForUpdatesEx(ctx, client.Client, &req)
+		if err != nil {
+			return err
+		}
+		set := res.Returnval
+		var refetched []types.ObjectUpdate
+		if set != nil {
+			refetched, err = refetchIndexed(ctx, client.Client, set, ps)
+			if err != nil {
+				return err
+			}
+		}
+
+		w.mux.Lock()
+		w.lastUpdate = time.Now()
+		if set != nil {
+			req.Version = set.Version
+			for _, fs := range set.FilterSet {
+				for _, u := range fs.ObjectSet {
+					applyObjectUpdate(objects, u)
+				}
+			}
+			for _, u := range refetched {
+				applyObjectUpdate(objects, u)
+			}
+			w.version = set.Version
+			if !synced && (set.Truncated == nil || !*set.Truncated) {
+				synced = true
+				w.objects = objects
+				w.lastResync = time.Now()
+			}
+		}
+		w.mux.Unlock()
+	}
+}
+
+// refetchIndexed retrieves the watched properties holding the array elements
+// changed in set, e.g. config.hardware.device for a change of
+// config.hardware.device["4000"], which only carries the element. It returns
+// them as updates assigning the whole properties.
+func refetchIndexed(ctx context.Context, client *vim25.Client, set *types.UpdateSet, ps []string) ([]types.ObjectUpdate, error) {
+	var res []types.ObjectUpdate
+	pc := property.DefaultCollector(client)
+	for obj, paths := range indexedChanges(set, ps) {
+		var content []types.ObjectContent
+		err := pc.Retrieve(ctx, []types.ManagedObjectReference{obj}, paths, &content)
+		if isManagedObjectNotFound(err) {
+			// The object is gone, its leave update comes next.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		u := types.ObjectUpdate{Kind: types.ObjectUpdateKindModify, Obj: obj}
+		for _, path := range paths {
+			change := types.PropertyChange{Name: path, Op: types.PropertyChangeOpRemove}
+			for _, o := range content {
+				for _, p := range o.PropSet {
+					if p.Name == path {
+						change.Op = types.PropertyChangeOpAssign
+						change.Val = p.Val
+					}
+				}
+			}
+			u.ChangeSet = append(u.ChangeSet, change)
+		}
+		res = append(res, u)
+	}
+	return res, nil
+}
+
+// indexedChanges returns by object the watched properties holding an array
+// element changed in set.
+func indexedChanges(set *types.UpdateSet, ps []string) map[types.ManagedObjectReference][]string {
+	res := make(map[types.ManagedObjectReference][]string)
+	for _, fs := range set.FilterSet {
+		for _, u := range fs.ObjectSet {
+			if u.Kind == types.ObjectUpdateKindLeave {
+				continue
+			}
+			for _, c := range u.ChangeSet {
+				if p, ok := indexedProperty(ps, c.Name); ok && !containsString(res[u.Obj], p) {
+					res[u.Obj] = append(res[u.Obj], p)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// indexedProperty returns the watched property holding the array element
+// named by a change, or false if the change is not an array element.
+func indexedProperty(ps []string, name string) (string, bool) {
+	i := strings.IndexByte(name, '[')
+	if i < 0 {
+		return "", false
+	}
+	base := name[:i]
+	for _, p := range ps {
+		if base == p || strings.HasPrefix(base, p+".") {
+			return p, true
+		}
+	}
+	return base, true
+}
+
+// applyObjectUpdate applies u to objects. Updated objects are copied, so
+// content already handed out to collectors is never modified. The changes
+// of array elements are skipped, their properties are refetched as a whole.
+func applyObjectUpdate(objects map[types.ManagedObjectReference]*types.ObjectContent, u types.ObjectUpdate) {
+	if u.Kind == types.ObjectUpdateKindLeave {
+		delete(objects, u.Obj)
+		return
+	}
+	o := &types.ObjectContent{Obj: u.Obj}
+	if prev, ok := objects[u.Obj]; ok {
+		o.PropSet = append(o.PropSet, prev.PropSet...)
+	}
+	for _, c := range u.ChangeSet {
+		if strings.ContainsRune(c.Name, '[') {
+			continue
+		}
+		props := o.PropSet[:0]
+		for _, p := range o.PropSet {
+			if p.Name != c.Name && !strings.HasPrefix(p.Name, c.Name+".") {
+				props = append(props, p)
+			}
+		}
+		if c.Op != types.PropertyChangeOpRemove && c.Op != types.PropertyChangeOpIndirectRemove {
+			props = append(props, types.DynamicProperty{Name: c.Name, Val: c.Val})
+		}
+		o.PropSet = props
+	}
+	objects[u.Obj] = o
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
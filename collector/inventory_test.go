@@ -0,0 +1,229 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestInventoryWatcherStale(t *testing.T) {
+	defer func(d time.Duration) { *inventoryMaxStaleness = d }(*inventoryMaxStaleness)
+	*inventoryMaxStaleness = 5 * time.Minute
+
+	ref := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	w := &inventoryWatcher{kind: "VirtualMachine", ps: []string{"name"}}
+	if _, ok := w.content([]string{"name"}); ok {
+		t.Error("objects served before the first synchronization")
+	}
+
+	w.objects = map[types.ManagedObjectReference]*types.ObjectContent{ref: {Obj: ref}}
+	w.lastUpdate = time.Now().Add(-time.Minute)
+	if content, ok := w.content([]string{"name"}); !ok || len(content) != 1 {
+		t.Errorf("want the synchronized objects, have %v, %v", content, ok)
+	}
+
+	w.lastUpdate = time.Now().Add(-10 * time.Minute)
+	if _, ok := w.content([]string{"name"}); ok {
+		t.Error("stale objects served")
+	}
+}
+
+func TestApplyObjectUpdate(t *testing.T) {
+	ref := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	objects := map[types.ManagedObjectReference]*types.ObjectContent{
+		ref: {Obj: ref, PropSet: []types.DynamicProperty{
+			{Name: "name", Val: "vm1"},
+			{Name: "runtime", Val: types.VirtualMachineRuntimeInfo{PowerState: types.VirtualMachinePowerStatePoweredOn}},
+			{Name: "runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOn},
+		}},
+	}
+	prev := objects[ref]
+	applyObjectUpdate(objects, types.ObjectUpdate{Kind: types.ObjectUpdateKindModify, Obj: ref, ChangeSet: []types.PropertyChange{
+		{Name: "runtime", Op: types.PropertyChangeOpAssign, Val: types.VirtualMachineRuntimeInfo{PowerState: types.VirtualMachinePowerStatePoweredOff}},
+		{Name: `config.hardware.device["4000"]`, Op: types.PropertyChangeOpAdd, Val: types.VirtualE1000{}},
+	}})
+
+	var vm mo.VirtualMachine
+	if err := mo.LoadObjectContent([]types.ObjectContent{*objects[ref]}, &vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "vm1" || vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		t.Errorf("want vm1 powered off, have %s %s", vm.Name, vm.Runtime.PowerState)
+	}
+	for _, p := range objects[ref].PropSet {
+		if p.Name != "name" && p.Name != "runtime" {
+			t.Errorf("unexpected property %s", p.Name)
+		}
+	}
+	if len(prev.PropSet) != 3 {
+		t.Errorf("previous content modified: %v", prev.PropSet)
+	}
+
+	applyObjectUpdate(objects, types.ObjectUpdate{Kind: types.ObjectUpdateKindLeave, Obj: ref})
+	if _, ok := objects[ref]; ok {
+		t.Error("object kept after leaving")
+	}
+}
+
+func TestIndexedProperty(t *testing.T) {
+	ps := []string{"config.hardware", "name", "triggeredAlarmState"}
+	for name, want := range map[string]string{
+		`config.hardware.device["4000"]`:         "config.hardware",
+		`config.hardware.device["4000"].backing`: "config.hardware",
+		`triggeredAlarmState["alarm-1.vm-1"]`:    "triggeredAlarmState",
+		`layoutEx.file[2]`:                       "layoutEx.file",
+		"name":                                   "",
+	} {
+		have, ok := indexedProperty(ps, name)
+		if ok != (want != "") || have != want {
+			t.Errorf("%s: want %q, have %q, %v", name, want, have, ok)
+		}
+	}
+}
+
+func TestRefetchIndexed(t *testing.T) {
+	s, stop := simulatorSession(t)
+	defer stop()
+	ctx := context.Background()
+	client, err := s.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := view.NewManager(client.Client).CreateContainerView(ctx, client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vms []mo.VirtualMachine
+	if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name"}, &vms); err != nil {
+		t.Fatal(err)
+	}
+	ref := vms[0].Self
+	missing := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-missing"}
+
+	ps := []string{"config.hardware.device", "name"}
+	objects := map[types.ManagedObjectReference]*types.ObjectContent{
+		ref: {Obj: ref, PropSet: []types.DynamicProperty{
+			{Name: "name", Val: "stale"},
+			{Name: "config.hardware.device", Val: types.ArrayOfVirtualDevice{}},
+		}},
+	}
+	set := &types.UpdateSet{FilterSet: []types.PropertyFilterUpdate{{ObjectSet: []types.ObjectUpdate{
+		{Kind: types.ObjectUpdateKindModify, Obj: ref, ChangeSet: []types.PropertyChange{
+			{Name: `config.hardware.device["4000"]`, Op: types.PropertyChangeOpAdd, Val: types.VirtualE1000{}},
+		}},
+		{Kind: types.ObjectUpdateKindModify, Obj: missing, ChangeSet: []types.PropertyChange{
+			{Name: `config.hardware.device["4000"]`, Op: types.PropertyChangeOpAdd, Val: types.VirtualE1000{}},
+		}},
+	}}}}
+	updates, err := refetchIndexed(ctx, client.Client, set, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Obj != ref {
+		t.Fatalf("want a single update of %s, have %+v", ref, updates)
+	}
+	applyObjectUpdate(objects, updates[0])
+
+	var vm mo.VirtualMachine
+	if err := mo.LoadObjectContent([]types.ObjectContent{*objects[ref]}, &vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "stale" {
+		t.Errorf("want the name left untouched, have %s", vm.Name)
+	}
+	if vm.Config == nil || len(vm.Config.Hardware.Device) == 0 {
+		t.Error("want the devices refetched from vc")
+	}
+}
+
+func TestInventoryFollowsUpdates(t *testing.T) {
+	defer func(resync, staleness time.Duration, wait int32) {
+		*inventoryResync, *inventoryMaxStaleness, inventoryMaxWait = resync, staleness, wait
+	}(*inventoryResync, *inventoryMaxStaleness, inventoryMaxWait)
+	// vcsim holds WaitForUpdatesEx until the max wait, even once canceled.
+	*inventoryResync, *inventoryMaxStaleness, inventoryMaxWait = time.Hour, 5*time.Minute, 1
+
+	s, stop := simulatorSession(t)
+	defer stop()
+	ctx := context.Background()
+	inventory := s.Inventory()
+	ps := []string{"name", "runtime.powerState"}
+
+	var vms []mo.VirtualMachine
+	eventually(t, "inventory never synchronized", func() bool {
+		ok, err := inventory.Retrieve("VirtualMachine", ps, &vms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	})
+	inventory.mux.Lock()
+	w := inventory.watchers["VirtualMachine"]
+	inventory.mux.Unlock()
+	w.mux.RLock()
+	resync := w.lastResync
+	w.mux.RUnlock()
+
+	var target mo.VirtualMachine
+	for _, vm := range vms {
+		if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+			target = vm
+			break
+		}
+	}
+	client, err := s.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := object.NewVirtualMachine(client.Client, target.Self)
+	task, err := vm.PowerOff(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	task, err = vm.Rename(ctx, "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "inventory never updated", func() bool {
+		vms = nil
+		if ok, err := inventory.Retrieve("VirtualMachine", ps, &vms); err != nil || !ok {
+			t.Fatalf("want the synchronized objects, have %v, %v", ok, err)
+		}
+		for _, vm := range vms {
+			if vm.Self == target.Self {
+				return vm.Name == "renamed" && vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff
+			}
+		}
+		return false
+	})
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if !w.lastResync.Equal(resync) {
+		t.Error("want the updates applied without a resynchronization")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	hierarchyTTL = kingpin.Flag("collector.hierarchy.ttl", "Duration the container hierarchy is reused between scrapes").Default("1m").Duration()
+)
+
+// Scrape holds the state shared by the collectors during a single scrape of
+// a vc. It is never modified once created, so concurrent collectors and
+// concurrent scrapes do not interfere.
+type Scrape struct {
+	ctx       context.Context
+	client    *govmomi.Client
+	hierarchy *Hierarchy
+}
+
+// newScrape connects to the vc of the session and snapshots its hierarchy.
+func newScrape(ctx context.Context, session *Session) (*Scrape, error) {
+	client, err := session.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hierarchy, err := session.hierarchy.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Scrape{ctx: ctx, client: client, hierarchy: hierarchy}, nil
+}
+
+// hierarchyCache reuses a Hierarchy until its TTL expires. A refreshed
+// Hierarchy is a new value, scrapes holding the previous one keep it.
+type hierarchyCache struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context) (*Hierarchy, error)
+	now   func() time.Time
+
+	mux       sync.Mutex
+	hierarchy *Hierarchy
+	fetched   time.Time
+}
+
+func newHierarchyCache(ttl time.Duration, fetch func(ctx context.Context) (*Hierarchy, error)) *hierarchyCache {
+	return &hierarchyCache{
+		ttl:   ttl,
+		fetch: fetch,
+		now:   time.Now,
+	}
+}
+
+// Get returns the cached Hierarchy, fetching it when missing or expired.
+// Concurrent callers wait for a single fetch.
+func (c *hierarchyCache) Get(ctx context.Context) (*Hierarchy, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.hierarchy != nil && c.now().Sub(c.fetched) < c.ttl {
+		return c.hierarchy, nil
+	}
+	h, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.hierarchy = h
+	c.fetched = c.now()
+	return h, nil
+}
+
+// Invalidate forces the next Get to fetch the Hierarchy.
+func (c *hierarchyCache) Invalidate() {
+	c.mux.Lock()
+	c.hierarchy = nil
+	c.mux.Unlock()
+}
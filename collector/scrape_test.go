@@ -0,0 +1,194 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func ref(kind string, value string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: kind, Value: value}
+}
+
+func node(obj types.ManagedObjectReference, name string, parent *types.ManagedObjectReference) types.ObjectContent {
+	o := types.ObjectContent{
+		Obj:     obj,
+		PropSet: []types.DynamicProperty{{Name: "name", Val: name}},
+	}
+	if parent != nil {
+		o.PropSet = append(o.PropSet, types.DynamicProperty{Name: "parent", Val: *parent})
+	}
+	return o
+}
+
+// testHierarchy builds root > dc > host folder > cluster > host and pool.
+func testHierarchy(dc string) *Hierarchy {
+	root := ref("Folder", "group-d1")
+	dcRef := ref("Datacenter", "datacenter-1")
+	hostFolder := ref("Folder", "group-h2")
+	cluster := ref("ClusterComputeResource", "domain-c1")
+	return NewHierarchy([]types.ObjectContent{
+		node(root, "Datacenters", nil),
+		node(dcRef, dc, &root),
+		node(hostFolder, "host", &dcRef),
+		node(cluster, "cluster1", &hostFolder),
+		node(ref("HostSystem", "host-1"), "esx1", &cluster),
+		node(ref("ResourcePool", "resgroup-1"), "Resources", &cluster),
+	})
+}
+
+func TestHierarchyParents(t *testing.T) {
+	h := testHierarchy("dc1")
+
+	host := ref("HostSystem", "host-1")
+	parents := h.Parents(&host)
+	if parents.dc != "dc1" || parents.cluster != "cluster1" || parents.spod != "NONE" {
+		t.Errorf("unexpected parents of host: %+v", parents)
+	}
+	if name := h.Name(&host); name != "esx1" {
+		t.Errorf("want host name esx1, have %s", name)
+	}
+
+	unknown := ref("ResourcePool", "resgroup-404")
+	parents = h.Parents(&unknown)
+	if parents.dc != "NONE" || parents.cluster != "NONE" {
+		t.Errorf("unexpected parents of unknown pool: %+v", parents)
+	}
+	if name := h.Name(nil); name != "NONE" {
+		t.Errorf("want NONE for nil reference, have %s", name)
+	}
+}
+
+func TestHierarchyCacheTTL(t *testing.T) {
+	var fetches int32
+	now := time.Unix(0, 0)
+	c := newHierarchyCache(time.Minute, func(ctx context.Context) (*Hierarchy, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return testHierarchy(fmt.Sprintf("dc%d", n)), nil
+	})
+	c.now = func() time.Time { return now }
+
+	wg := sync.WaitGroup{}
+	results := make([]*Hierarchy, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h, err := c.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = h
+		}(i)
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("want 1 fetch for concurrent gets, have %d", fetches)
+	}
+	for _, h := range results {
+		if h != results[0] {
+			t.Fatal("concurrent gets returned different hierarchies")
+		}
+	}
+
+	now = now.Add(30 * time.Second)
+	if h, _ := c.Get(context.Background()); h != results[0] || fetches != 1 {
+		t.Errorf("hierarchy refetched before its TTL")
+	}
+
+	now = now.Add(time.Minute)
+	h, _ := c.Get(context.Background())
+	if h == results[0] || fetches != 2 {
+		t.Errorf("hierarchy not refetched after its TTL")
+	}
+	host := ref("HostSystem", "host-1")
+	if dc := results[0].Parents(&host).dc; dc != "dc1" {
+		t.Errorf("previous hierarchy modified by refetch, have dc %s", dc)
+	}
+
+	c.Invalidate()
+	if _, _ = c.Get(context.Background()); fetches != 3 {
+		t.Errorf("hierarchy not refetched after invalidation")
+	}
+}
+
+var testDesc = prometheus.NewDesc("govc_test_dc", "test", []string{"collector", "dc"}, nil)
+
+// hierarchyCollector reports the datacenter of a host from the scrape it is
+// given, after yielding to the other collectors.
+type hierarchyCollector struct {
+	name string
+}
+
+func (c *hierarchyCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) error {
+	host := ref("HostSystem", "host-1")
+	time.Sleep(time.Millisecond)
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1, c.name, scrape.hierarchy.Parents(&host).dc)
+	return nil
+}
+
+func TestConcurrentScrapesIsolation(t *testing.T) {
+	collectors := make(map[string]Collector)
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("c%d", i)
+		collectors[name] = &hierarchyCollector{name: name}
+	}
+	n := MainCollector{Collectors: collectors, logger: log.NewNopLogger()}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dc := fmt.Sprintf("dc%d", i)
+			scrape := &Scrape{ctx: context.Background(), hierarchy: testHierarchy(dc)}
+			ch := make(chan prometheus.Metric)
+			go func() {
+				n.collect(scrape, ch)
+				close(ch)
+			}()
+			seen := 0
+			for m := range ch {
+				if m.Desc() != testDesc {
+					continue
+				}
+				seen++
+				var pb dto.Metric
+				if err := m.Write(&pb); err != nil {
+					t.Error(err)
+					continue
+				}
+				for _, l := range pb.Label {
+					if l.GetName() == "dc" && l.GetValue() != dc {
+						t.Errorf("scrape of %s received a metric of %s", dc, l.GetValue())
+					}
+				}
+			}
+			if seen != len(collectors) {
+				t.Errorf("scrape of %s: want %d metrics, have %d", dc, len(collectors), seen)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "snapshot_age_seconds"),
+		"govc_exporter: Age of the served metrics snapshot.",
+		nil,
+		nil,
+	)
+)
+
+type snapshot struct {
+	metrics   []prometheus.Metric
+	timestamp time.Time
+}
+
+// SnapshotCollector runs a MainCollector in the background and serves the
+// metrics of its last run, so scrapes do not hit the vc.
+type SnapshotCollector struct {
+	collector *MainCollector
+	interval  time.Duration
+	maxAge    time.Duration
+	logger    log.Logger
+
+	// last holds the *snapshot of the last completed run.
+	last atomic.Value
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSnapshotCollector creates a SnapshotCollector running nc every interval.
+// Snapshots older than maxAge are dropped, 0 keeps them forever.
+func NewSnapshotCollector(logger log.Logger, nc *MainCollector, interval time.Duration, maxAge time.Duration) *SnapshotCollector {
+	return &SnapshotCollector{
+		collector: nc,
+		interval:  interval,
+		maxAge:    maxAge,
+		logger:    logger,
+	}
+}
+
+// Start runs a first collection and starts the background refresh.
+func (s *SnapshotCollector) Start() {
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		s.refresh()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.refresh()
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh and waits for a running collection.
+func (s *SnapshotCollector) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *SnapshotCollector) refresh() {
+	begin := time.Now()
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	metrics := []prometheus.Metric{}
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	s.collector.Collect(ch)
+	close(ch)
+	<-done
+	s.last.Store(&snapshot{metrics: metrics, timestamp: time.Now()})
+	level.Debug(s.logger).Log("msg", "snapshot refreshed", "metrics", len(metrics), "duration_seconds", time.Since(begin).Seconds())
+}
+
+// Describe implements the prometheus.Collector interface.
+func (s *SnapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.collector.Describe(ch)
+	ch <- snapshotAgeDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (s *SnapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	last, ok := s.last.Load().(*snapshot)
+	if !ok {
+		return
+	}
+	age := time.Since(last.timestamp)
+	ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, age.Seconds())
+	if s.maxAge > 0 && age > s.maxAge {
+		level.Warn(s.logger).Log("msg", "snapshot is stale, dropping it", "age_seconds", age.Seconds())
+		return
+	}
+	for _, m := range last.metrics {
+		ch <- m
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	vcInsecure   = kingpin.Flag("collector.vc.tls.insecure-skip-verify", "Do not verify the vc api certificate").Default("false").Bool()
+	vcCAFile     = kingpin.Flag("collector.vc.tls.ca-file", "CA bundle used to verify the vc api certificate, system roots if empty").Default("").String()
+	vcThumbprint = kingpin.Flag("collector.vc.tls.thumbprint", "SHA-256 thumbprint the vc api certificate must match, replaces the CA verification").Default("").String()
+	vcServerName = kingpin.Flag("collector.vc.tls.server-name", "Server name used to verify the vc api certificate, host of the url if empty").Default("").String()
+)
+
+// TLSConfig configures the verification of the vc api certificate.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CAFile             string `yaml:"ca_file"`
+	Thumbprint         string `yaml:"thumbprint"`
+	ServerName         string `yaml:"server_name"`
+}
+
+func defaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		InsecureSkipVerify: *vcInsecure,
+		CAFile:             *vcCAFile,
+		Thumbprint:         *vcThumbprint,
+		ServerName:         *vcServerName,
+	}
+}
+
+// errThumbprintMismatch is returned when the certificate does not match the
+// pinned thumbprint.
+type errThumbprintMismatch struct {
+	expected string
+	actual   string
+}
+
+func (e errThumbprintMismatch) Error() string {
+	return fmt.Sprintf("certificate thumbprint %s does not match %s", e.actual, e.expected)
+}
+
+// normalizeThumbprint accepts the colon separated format used by govc as
+// well as plain hexadecimal.
+func normalizeThumbprint(thumbprint string) string {
+	return strings.ToLower(strings.Replace(thumbprint, ":", "", -1))
+}
+
+// ThumbprintSHA256 returns the SHA-256 thumbprint of a certificate in the
+// colon separated format used by govc.
+func ThumbprintSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	res := make([]string, len(sum))
+	for i, b := range sum {
+		res[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(res, ":")
+}
+
+// Build returns the tls.Config verifying the vc certificate.
+func (c TLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		pool := x509.NewCertPool()
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.Thumbprint != "" {
+		expected := normalizeThumbprint(c.Thumbprint)
+		if _, err := hex.DecodeString(expected); err != nil || len(expected) != 2*sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 thumbprint: %s", c.Thumbprint)
+		}
+		// The pinned thumbprint replaces the chain verification.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			actual := ThumbprintSHA256(cert)
+			if normalizeThumbprint(actual) != expected {
+				return errThumbprintMismatch{expected: c.Thumbprint, actual: actual}
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// isTLSVerifyError reports whether err comes from the verification of the
+// vc certificate.
+func isTLSVerifyError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var mismatch errThumbprintMismatch
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &mismatch)
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type alarmCollector struct {
+	vcCollector
+	triggered typedDesc
+}
+
+const (
+	alarmCollectorSubsystem = "alarm"
+)
+
+func init() {
+	registerCollector(alarmCollectorSubsystem, defaultDisabled, NewAlarmCollector)
+}
+
+// NewAlarmCollector returns a new Collector exposing the triggered alarms.
+func NewAlarmCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	labels := []string{"vc", "dc", "entity_type", "entity", "entity_id", "alarm", "status", "acknowledged"}
+
+	res := alarmCollector{
+		triggered: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, alarmCollectorSubsystem, "triggered_timestamp_seconds"),
+			"alarm triggered on an entity, time it was triggered", labels, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, alarmCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *alarmCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	states, err := c.apiRetrieve(scrape)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve triggered alarms", "err", err)
+		return err
+	}
+
+	level.Debug(c.logger).Log("msg", "triggered alarms retrieved", "num", len(states))
+	if len(states) == 0 {
+		return nil
+	}
+
+	entities, err := c.entities(scrape, states)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve alarm entities", "err", err)
+		return err
+	}
+	alarms, err := c.alarmNames(scrape, states)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve alarm definitions", "err", err)
+		return err
+	}
+
+	vc := c.session.url
+	for _, state := range states {
+		alarm, ok := alarms[state.Alarm]
+		if !ok {
+			alarm = "NONE"
+		}
+		if !c.filter.Match(alarm) {
+			continue
+		}
+		entity, ok := entities[state.Entity]
+		name := "NONE"
+		parents := scrape.hierarchy.Parents(nil)
+		if ok {
+			name = entity.Name
+			// the containers are located from themselves, so that an alarm
+			// on a datacenter is labelled with it.
+			parents = scrape.hierarchy.Parents(&entity.Self)
+			if parents.dc == "NONE" {
+				parents = scrape.hierarchy.Parents(entity.Parent)
+			}
+		}
+		acknowledged := state.Acknowledged != nil && *state.Acknowledged
+		ch <- c.triggered.mustNewConstMetric(
+			float64(state.Time.Unix()),
+			vc, parents.dc, state.Entity.Type, name, state.Entity.Value, alarm,
+			string(state.OverallStatus), strconv.FormatBool(acknowledged),
+		)
+	}
+	return nil
+}
+
+// apiRetrieve returns the alarms triggered in the inventory, the root folder
+// holding the alarms of all its descendants.
+func (c *alarmCollector) apiRetrieve(scrape *Scrape) ([]types.AlarmState, error) {
+	var root mo.Folder
+	pc := property.DefaultCollector(scrape.client.Client)
+	err := pc.RetrieveOne(scrape.ctx, scrape.client.ServiceContent.RootFolder, []string{"triggeredAlarmState"}, &root)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	res := make([]types.AlarmState, 0, len(root.TriggeredAlarmState))
+	for _, state := range root.TriggeredAlarmState {
+		if seen[state.Key] {
+			continue
+		}
+		seen[state.Key] = true
+		res = append(res, state)
+	}
+	return res, nil
+}
+
+// entities returns the name and parent of the entities of the alarms.
+func (c *alarmCollector) entities(scrape *Scrape, states []types.AlarmState) (map[types.ManagedObjectReference]mo.ManagedEntity, error) {
+	var refs []types.ManagedObjectReference
+	for _, state := range states {
+		refs = append(refs, state.Entity)
+	}
+	var items []mo.ManagedEntity
+	if err := retrieveExisting(scrape, uniqueRefs(refs), []string{"name", "parent"}, &items); err != nil {
+		return nil, err
+	}
+	res := make(map[types.ManagedObjectReference]mo.ManagedEntity, len(items))
+	for _, item := range items {
+		res[item.Self] = item
+	}
+	return res, nil
+}
+
+// alarmNames returns the names of the triggered alarms.
+func (c *alarmCollector) alarmNames(scrape *Scrape, states []types.AlarmState) (map[types.ManagedObjectReference]string, error) {
+	var refs []types.ManagedObjectReference
+	for _, state := range states {
+		refs = append(refs, state.Alarm)
+	}
+	var items []mo.Alarm
+	if err := retrieveExisting(scrape, uniqueRefs(refs), []string{"info.name"}, &items); err != nil {
+		return nil, err
+	}
+	res := make(map[types.ManagedObjectReference]string, len(items))
+	for _, item := range items {
+		res[item.Self] = item.Info.Name
+	}
+	return res, nil
+}
+
+// retrieveExisting loads the properties of refs into dst. When one of the
+// objects is gone, e.g. deleted since its alarm was listed, they are
+// retrieved one by one and the missing ones skipped.
+func retrieveExisting(scrape *Scrape, refs []types.ManagedObjectReference, ps []string, dst interface{}) error {
+	pc := property.DefaultCollector(scrape.client.Client)
+	var content []types.ObjectContent
+	err := pc.Retrieve(scrape.ctx, refs, ps, &content)
+	if isManagedObjectNotFound(err) {
+		content, err = nil, nil
+		for _, ref := range refs {
+			var one []types.ObjectContent
+			rerr := pc.Retrieve(scrape.ctx, []types.ManagedObjectReference{ref}, ps, &one)
+			if isManagedObjectNotFound(rerr) {
+				continue
+			}
+			if rerr != nil {
+				return rerr
+			}
+			content = append(content, one...)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return mo.LoadObjectContent(content, dst)
+}
+
+func uniqueRefs(refs []types.ManagedObjectReference) []types.ManagedObjectReference {
+	seen := make(map[types.ManagedObjectReference]bool)
+	res := make([]types.ManagedObjectReference, 0, len(refs))
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			res = append(res, ref)
+		}
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestAlarmCollector(t *testing.T) {
+	c, err := NewAlarmCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	triggered := time.Unix(1600000000, 0)
+	var vms []mo.VirtualMachine
+	metrics := collectSimulator(t, c, func(ctx context.Context, vc *vim25.Client) {
+		v, err := view.NewManager(vc).CreateContainerView(ctx, vc.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name"}, &vms); err != nil {
+			t.Fatal(err)
+		}
+		// Two vms of the same name with the same alarm, and an alarm on a
+		// deleted vm, whose alarm definition is gone as well.
+		alarm := types.ManagedObjectReference{Type: "Alarm", Value: "alarm-1"}
+		var states []types.AlarmState
+		for _, ref := range []types.ManagedObjectReference{vms[0].Self, vms[1].Self, {Type: "VirtualMachine", Value: "vm-deleted"}} {
+			states = append(states, types.AlarmState{
+				Key:           "alarm-1." + ref.Value,
+				Entity:        ref,
+				Alarm:         alarm,
+				OverallStatus: types.ManagedEntityStatusRed,
+				Time:          triggered,
+			})
+		}
+		for _, vm := range vms[:2] {
+			simulator.Map.Get(vm.Self).(*simulator.VirtualMachine).Name = "dup"
+		}
+		simulator.Map.Get(vc.ServiceContent.RootFolder).(*simulator.Folder).TriggeredAlarmState = states
+	})
+
+	for _, series := range []string{
+		`govc_alarm_triggered_timestamp_seconds{acknowledged="false",alarm="NONE",dc="DC0",entity="dup",entity_id="` + vms[0].Self.Value + `",entity_type="VirtualMachine",status="red",vc="vcsim"}`,
+		`govc_alarm_triggered_timestamp_seconds{acknowledged="false",alarm="NONE",dc="DC0",entity="dup",entity_id="` + vms[1].Self.Value + `",entity_type="VirtualMachine",status="red",vc="vcsim"}`,
+		`govc_alarm_triggered_timestamp_seconds{acknowledged="false",alarm="NONE",dc="NONE",entity="NONE",entity_id="vm-deleted",entity_type="VirtualMachine",status="red",vc="vcsim"}`,
+	} {
+		if have, ok := metrics[series]; !ok || have != float64(triggered.Unix()) {
+			t.Errorf("%s: want %d, have %v", series, triggered.Unix(), have)
+		}
+	}
+	if len(metrics) != 3 {
+		t.Errorf("want 3 series, have %v", metrics)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type clusterCollector struct {
+	vcCollector
+	totalCPU               typedDesc
+	totalMemory            typedDesc
+	effectiveCPU           typedDesc
+	effectiveMemory        typedDesc
+	hosts                  typedDesc
+	effectiveHosts         typedDesc
+	haCurrentFailoverLevel typedDesc
+	overallStatus          typedDesc
+	drsEnabled             typedDesc
+	haEnabled              typedDesc
+	admissionControl       typedDesc
+	haFailoverLevel        typedDesc
+}
+
+const (
+	clusterCollectorSubsystem = "cluster"
+)
+
+func init() {
+	registerCollector(clusterCollectorSubsystem, defaultDisabled, NewClusterCollector)
+	registerProperties(clusterCollectorSubsystem, []string{
+		"configurationEx",
+	})
+}
+
+// NewClusterCollector returns a new Collector exposing cluster resources and
+// DRS and HA settings.
+func NewClusterCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	labels := []string{"vc", "dc", "name"}
+
+	res := clusterCollector{
+		totalCPU: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "total_cpu_mhz"),
+			"cluster aggregated cpu of the hosts in mhz", labels, nil), prometheus.GaugeValue},
+		totalMemory: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "total_memory_bytes"),
+			"cluster aggregated memory of the hosts in bytes", labels, nil), prometheus.GaugeValue},
+		effectiveCPU: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "effective_cpu_mhz"),
+			"cluster cpu available to vms in mhz", labels, nil), prometheus.GaugeValue},
+		effectiveMemory: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "effective_memory_bytes"),
+			"cluster memory available to vms in bytes", labels, nil), prometheus.GaugeValue},
+		hosts: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "hosts"),
+			"cluster number of hosts", labels, nil), prometheus.GaugeValue},
+		effectiveHosts: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "effective_hosts"),
+			"cluster number of hosts available to vms", labels, nil), prometheus.GaugeValue},
+		haCurrentFailoverLevel: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "ha_current_failover_level"),
+			"cluster number of host failures ha can currently tolerate", labels, nil), prometheus.GaugeValue},
+		overallStatus: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "overall_status"),
+			"cluster overall status, 1 for the current status", append(labels, "status"), nil), prometheus.GaugeValue},
+		drsEnabled: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "drs_enabled"),
+			"cluster drs enabled", append(labels, "automation_level"), nil), prometheus.GaugeValue},
+		haEnabled: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "ha_enabled"),
+			"cluster ha enabled", labels, nil), prometheus.GaugeValue},
+		admissionControl: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "ha_admission_control_enabled"),
+			"cluster ha admission control enabled", append(labels, "policy"), nil), prometheus.GaugeValue},
+		haFailoverLevel: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, clusterCollectorSubsystem, "ha_configured_failover_level"),
+			"cluster number of host failures ha is configured to tolerate", labels, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, clusterCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *clusterCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+
+	items, err := c.apiRetrieve(scrape)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve cluster", "err", err)
+		return err
+	}
+
+	vc := c.session.url
+
+	level.Debug(c.logger).Log("msg", "cluster retrieved", "num", len(items))
+
+	for _, item := range items {
+		name := item.Name
+		if !c.filter.Match(name) {
+			continue
+		}
+		tmp := scrape.hierarchy.Parents(item.Parent)
+		labels := []string{vc, tmp.dc, name}
+		mb := int64(1024 * 1024)
+
+		if item.Summary != nil {
+			summary := item.Summary.GetComputeResourceSummary()
+			ch <- c.totalCPU.mustNewConstMetric(float64(summary.TotalCpu), labels...)
+			ch <- c.totalMemory.mustNewConstMetric(float64(summary.TotalMemory), labels...)
+			ch <- c.effectiveCPU.mustNewConstMetric(float64(summary.EffectiveCpu), labels...)
+			ch <- c.effectiveMemory.mustNewConstMetric(float64(summary.EffectiveMemory*mb), labels...)
+			ch <- c.hosts.mustNewConstMetric(float64(summary.NumHosts), labels...)
+			ch <- c.effectiveHosts.mustNewConstMetric(float64(summary.NumEffectiveHosts), labels...)
+			ch <- c.overallStatus.mustNewConstMetric(1, append(labels, string(summary.OverallStatus))...)
+			if cs, ok := item.Summary.(*types.ClusterComputeResourceSummary); ok {
+				ch <- c.haCurrentFailoverLevel.mustNewConstMetric(float64(cs.CurrentFailoverLevel), labels...)
+			}
+		}
+
+		c.updateConfig(ch, labels, item.ConfigurationEx)
+	}
+	return nil
+}
+
+// updateConfig exposes the drs and ha settings of a cluster, when its
+// configuration was retrieved.
+func (c *clusterCollector) updateConfig(ch chan<- prometheus.Metric, labels []string, configurationEx types.BaseComputeResourceConfigInfo) {
+	config, ok := configurationEx.(*types.ClusterConfigInfoEx)
+	if !ok || config == nil {
+		return
+	}
+	drs := config.DrsConfig
+	ch <- c.drsEnabled.mustNewConstMetric(b2f(drs.Enabled != nil && *drs.Enabled), append(labels, drsAutomationLevel(drs))...)
+	das := config.DasConfig
+	ch <- c.haEnabled.mustNewConstMetric(b2f(das.Enabled != nil && *das.Enabled), labels...)
+	policy, failoverLevel := admissionControlPolicy(das)
+	ch <- c.admissionControl.mustNewConstMetric(b2f(das.AdmissionControlEnabled != nil && *das.AdmissionControlEnabled), append(labels, policy)...)
+	ch <- c.haFailoverLevel.mustNewConstMetric(float64(failoverLevel), labels...)
+}
+
+// drsAutomationLevel returns the default drs automation level of the vms,
+// e.g. fullyAutomated, NONE if unset.
+func drsAutomationLevel(drs types.ClusterDrsConfigInfo) string {
+	if drs.DefaultVmBehavior == "" {
+		return "NONE"
+	}
+	return string(drs.DefaultVmBehavior)
+}
+
+// admissionControlPolicy returns the name of the ha admission control policy
+// and the number of host failures it tolerates.
+func admissionControlPolicy(das types.ClusterDasConfigInfo) (string, int32) {
+	switch p := das.AdmissionControlPolicy.(type) {
+	case *types.ClusterFailoverLevelAdmissionControlPolicy:
+		if p != nil {
+			return "slot_policy", p.FailoverLevel
+		}
+	case *types.ClusterFailoverResourcesAdmissionControlPolicy:
+		if p != nil {
+			return "resource_percentage", p.FailoverLevel
+		}
+	case *types.ClusterFailoverHostAdmissionControlPolicy:
+		if p != nil {
+			return "dedicated_hosts", p.FailoverLevel
+		}
+	case nil:
+	default:
+		return "other", das.FailoverLevel
+	}
+	// No policy, possibly given as a nil pointer.
+	return "NONE", das.FailoverLevel
+}
+
+func (c *clusterCollector) apiRetrieve(scrape *Scrape) ([]mo.ClusterComputeResource, error) {
+	var items []mo.ClusterComputeResource
+
+	err := c.retrieve(
+		scrape,
+		"ClusterComputeResource",
+		[]string{
+			"name",
+			"parent",
+			"summary",
+		},
+		&items,
+	)
+	return items, err
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestAdmissionControlPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy types.BaseClusterDasAdmissionControlPolicy
+		name   string
+		level  int32
+	}{
+		{&types.ClusterFailoverLevelAdmissionControlPolicy{FailoverLevel: 1}, "slot_policy", 1},
+		{&types.ClusterFailoverResourcesAdmissionControlPolicy{FailoverLevel: 2}, "resource_percentage", 2},
+		{&types.ClusterFailoverHostAdmissionControlPolicy{FailoverLevel: 3}, "dedicated_hosts", 3},
+		{&types.ClusterDasAdmissionControlPolicy{}, "other", 4},
+		{nil, "NONE", 4},
+		{(*types.ClusterFailoverLevelAdmissionControlPolicy)(nil), "NONE", 4},
+	} {
+		name, level := admissionControlPolicy(types.ClusterDasConfigInfo{AdmissionControlPolicy: test.policy, FailoverLevel: 4})
+		if name != test.name || level != test.level {
+			t.Errorf("%T: want %s %d, have %s %d", test.policy, test.name, test.level, name, level)
+		}
+	}
+}
+
+func TestClusterConfig(t *testing.T) {
+	c, err := NewClusterCollector(log.NewNopLogger(), nil, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := c.(*clusterCollector)
+	enabled := true
+	for _, test := range []struct {
+		config types.BaseComputeResourceConfigInfo
+		want   map[string]string
+	}{
+		{nil, nil},
+		{(*types.ClusterConfigInfoEx)(nil), nil},
+		{&types.ComputeResourceConfigInfo{}, nil},
+		{&types.ClusterConfigInfoEx{}, map[string]string{
+			"govc_cluster_drs_enabled":                  "NONE",
+			"govc_cluster_ha_enabled":                   "",
+			"govc_cluster_ha_admission_control_enabled": "NONE",
+			"govc_cluster_ha_configured_failover_level": "",
+		}},
+		{&types.ClusterConfigInfoEx{
+			DrsConfig: types.ClusterDrsConfigInfo{Enabled: &enabled, DefaultVmBehavior: types.DrsBehaviorFullyAutomated},
+			DasConfig: types.ClusterDasConfigInfo{
+				Enabled:                 &enabled,
+				AdmissionControlEnabled: &enabled,
+				AdmissionControlPolicy:  &types.ClusterFailoverResourcesAdmissionControlPolicy{FailoverLevel: 1},
+			},
+		}, map[string]string{
+			"govc_cluster_drs_enabled":                  "fullyAutomated",
+			"govc_cluster_ha_enabled":                   "",
+			"govc_cluster_ha_admission_control_enabled": "resource_percentage",
+			"govc_cluster_ha_configured_failover_level": "",
+		}},
+	} {
+		ch := make(chan prometheus.Metric, 10)
+		cluster.updateConfig(ch, []string{"vc", "dc", "cluster"}, test.config)
+		close(ch)
+		have := make(map[string]string)
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			var setting string
+			for _, label := range pb.Label {
+				if label.GetName() == "automation_level" || label.GetName() == "policy" {
+					setting = label.GetValue()
+				}
+			}
+			name := seriesName(m.Desc(), &pb)
+			have[name[:strings.IndexByte(name, '{')]] = setting
+		}
+		if len(have) != len(test.want) {
+			t.Errorf("%#v: want %d metrics, have %v", test.config, len(test.want), have)
+			continue
+		}
+		for name, setting := range test.want {
+			if have[name] != setting {
+				t.Errorf("%#v: want %s %q, have %q", test.config, name, setting, have[name])
+			}
+		}
+	}
+}
+
+func TestClusterCollector(t *testing.T) {
+	c, err := NewClusterCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectSimulator(t, c)
+	for _, series := range []string{
+		`govc_cluster_hosts{dc="DC0",name="DC0_C0",vc="vcsim"}`,
+		`govc_cluster_total_cpu_mhz{dc="DC0",name="DC0_C0",vc="vcsim"}`,
+		`govc_cluster_ha_enabled{dc="DC0",name="DC0_C0",vc="vcsim"}`,
+	} {
+		if _, ok := metrics[series]; !ok {
+			t.Errorf("missing %s", series)
+		}
+	}
+	if hosts := metrics[`govc_cluster_hosts{dc="DC0",name="DC0_C0",vc="vcsim"}`]; hosts != 3 {
+		t.Errorf("want 3 hosts in DC0_C0, have %v", hosts)
+	}
+}
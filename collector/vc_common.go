@@ -15,27 +15,61 @@ package collector
 
 import (
 	"context"
+	"fmt"
 	"net/url"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vmware/govmomi"
-	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/session/keepalive"
 	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25"
-	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	vcPassword       = kingpin.Flag("collector.vc.password", "vc api password").Envar("VC_PASSWORD").Required().String()
-	vcUsername       = kingpin.Flag("collector.vc.username", "vc api username").Envar("VC_USERNAME").Required().String()
-	vcURL            = kingpin.Flag("collector.vc.url", "vc api username").Envar("VC_URL").Required().String()
+	vcPassword       = kingpin.Flag("collector.vc.password", "vc api password").Envar("VC_PASSWORD").String()
+	vcUsername       = kingpin.Flag("collector.vc.username", "vc api username").Envar("VC_USERNAME").String()
+	vcURL            = kingpin.Flag("collector.vc.url", "vc api url, optional when only /probe is used").Envar("VC_URL").String()
+	vcKeepAlive      = kingpin.Flag("collector.vc.keepalive", "Interval between keep alive requests on the vc api session").Default("5m").Duration()
 	useIsecSpecifics = kingpin.Flag("collector.intrinsec", "Enable intrinsec specific features").Default("false").Bool()
-	cache            = NewParentsCache()
+	sessions         = NewSessionRegistry()
+)
+
+var (
+	sessionAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "age_seconds"),
+		"govc_exporter: Age of the current vc api session.",
+		[]string{"vc"},
+		nil,
+	)
+	sessionReloginDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "relogin_total"),
+		"govc_exporter: Number of times the vc api session has been re-established.",
+		[]string{"vc"},
+		nil,
+	)
+	sessionLoginFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "login_failures_total"),
+		"govc_exporter: Number of failed vc api logins.",
+		[]string{"vc"},
+		nil,
+	)
+	sessionTLSFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "session", "tls_verify_failures_total"),
+		"govc_exporter: Number of vc api connections rejected by the certificate verification.",
+		[]string{"vc"},
+		nil,
+	)
 )
 
 type Parents struct {
@@ -44,145 +78,549 @@ type Parents struct {
 	spod    string
 }
 
-type ParentsCache struct {
-	cache map[types.ManagedObjectReference]Parents
-	mux   sync.Mutex
+// hierarchyKinds are the containers retrieved to locate the other objects,
+// and the datastores and distributed portgroups, named from the references
+// of the vms and hosts.
+// StoragePod and ClusterComputeResource are subtypes of Folder and
+// ComputeResource.
+var hierarchyKinds = []string{
+	"Folder", "Datacenter", "ComputeResource", "HostSystem", "ResourcePool",
+	"Datastore", "DistributedVirtualPortgroup",
 }
 
-func NewParentsCache() *ParentsCache {
-	return &ParentsCache{
-		cache: make(map[types.ManagedObjectReference]Parents),
-	}
+type hierarchyNode struct {
+	name   string
+	parent *types.ManagedObjectReference
 }
 
-func (c *ParentsCache) Add(mor types.ManagedObjectReference, val Parents) {
-	c.mux.Lock()
-	c.cache[mor] = val
-	c.mux.Unlock()
+// Hierarchy is the tree of the vc containers, resolved in a single property
+// retrieval and used to label the objects with their location.
+type Hierarchy struct {
+	nodes map[types.ManagedObjectReference]hierarchyNode
 }
 
-func (c *ParentsCache) Get(mor types.ManagedObjectReference) (Parents, bool) {
-	c.mux.Lock()
-	val, ok := c.cache[mor]
-	c.mux.Unlock()
-	return val, ok
+// NewHierarchy builds a Hierarchy from the name and parent properties of the
+// containers.
+func NewHierarchy(content []types.ObjectContent) *Hierarchy {
+	h := &Hierarchy{
+		nodes: make(map[types.ManagedObjectReference]hierarchyNode, len(content)),
+	}
+	for _, o := range content {
+		var node hierarchyNode
+		for _, p := range o.PropSet {
+			switch p.Name {
+			case "name":
+				node.name, _ = p.Val.(string)
+			case "parent":
+				if ref, ok := p.Val.(types.ManagedObjectReference); ok {
+					node.parent = &ref
+				}
+			}
+		}
+		h.nodes[o.Obj] = node
+	}
+	return h
 }
 
-func (c *ParentsCache) Flush() {
-	c.mux.Lock()
-	c.cache = make(map[types.ManagedObjectReference]Parents)
-	c.mux.Unlock()
+func retrieveHierarchy(ctx context.Context, client *vim25.Client) (*Hierarchy, error) {
+	m := view.NewManager(client)
+	v, err := m.CreateContainerView(ctx, client.ServiceContent.RootFolder, hierarchyKinds, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = v.Destroy(ctx)
+	}()
+
+	var content []types.ObjectContent
+	err = v.Retrieve(ctx, []string{"ManagedEntity"}, []string{"name", "parent"}, &content)
+	if err != nil {
+		return nil, err
+	}
+	return NewHierarchy(content), nil
 }
 
-func getParents(ctx context.Context, logger log.Logger, client *vim25.Client, me mo.ManagedEntity) Parents {
-	var entity mo.ManagedEntity
-	var cur *types.ManagedObjectReference
+// Name returns the name of a container, NONE if unknown.
+func (h *Hierarchy) Name(ref *types.ManagedObjectReference) string {
+	if ref == nil {
+		return "NONE"
+	}
+	node, ok := h.nodes[*ref]
+	if !ok {
+		return "NONE"
+	}
+	return node.name
+}
+
+// Parents returns the datacenter, cluster and storage pod containing ref,
+// ref included.
+func (h *Hierarchy) Parents(ref *types.ManagedObjectReference) Parents {
 	res := Parents{
 		dc:      "NONE",
 		cluster: "NONE",
 		spod:    "NONE",
 	}
 
-	if me.Parent == nil {
-		return res
-	}
-	cached, ok := cache.Get(*me.Parent)
-	if ok {
-		return cached
-	}
-
-	pc := property.DefaultCollector(client)
-
-	cur = me.Parent
-	for {
-		err := pc.RetrieveOne(ctx, *cur, []string{"name", "parent"}, &entity)
-		if err != nil {
-			return Parents{"ERROR", "ERROR", "ERROR"}
+	for cur := ref; cur != nil; {
+		node, ok := h.nodes[*cur]
+		if !ok {
+			break
 		}
 		if cur.Type == "StoragePod" {
-			res.spod = entity.Name
+			res.spod = node.name
 		}
 		if cur.Type == "ClusterComputeResource" {
-			res.cluster = entity.Name
+			res.cluster = node.name
 		}
 		if cur.Type == "Datacenter" {
-			res.dc = entity.Name
-			break
-		}
-		if entity.Parent == nil {
+			res.dc = node.name
 			break
 		}
-		cur = entity.Parent
+		cur = node.parent
 	}
-	cache.Add(*me.Parent, res)
 	return res
 }
 
-func getVMPool(ctx context.Context, logger log.Logger, client *vim25.Client, me mo.VirtualMachine) *mo.ManagedEntity {
-	if me.ResourcePool == nil {
+func b2f(val bool) float64 {
+	if val {
+		return 1.0
+	}
+	return 0.0
+}
+
+// Session keeps a single authenticated vc api client alive across scrapes.
+// It is shared by every collector scraping the same vc with the same user.
+type Session struct {
+	logger log.Logger
+	url    string
+	tls    TLSConfig
+
+	mux           sync.Mutex
+	credentials   *credentialsLoader
+	client        *govmomi.Client
+	loginTime     time.Time
+	relogins      uint64
+	loginFailures uint64
+	tlsFailures   uint64
+	inventory     *Inventory
+	hierarchy     *hierarchyCache
+	events        *eventTail
+	tasks         *taskTracker
+	perfCounters  map[string]int32
+}
+
+type eventCountKey struct {
+	dc      string
+	cluster string
+	kind    string
+}
+
+// eventTail follows the events of a session through an event history
+// collector. Its position and counts are kept between scrapes and collector
+// rebuilds.
+type eventTail struct {
+	mux sync.Mutex
+
+	client    *govmomi.Client
+	collector *object.HistoryCollector
+	types     []string
+
+	// lastTime and lastKey locate the last event read, the collector is
+	// recreated from there when lost with the vc session.
+	lastTime time.Time
+	lastKey  int32
+	counts   map[eventCountKey]float64
+}
+
+func (s *Session) eventTail() *eventTail {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.events == nil {
+		s.events = &eventTail{counts: make(map[eventCountKey]float64)}
+	}
+	return s.events
+}
+
+type taskCountKey struct {
+	task  string
+	state string
+}
+
+type taskHistogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+// taskTracker counts the tasks of a session as they complete. The recent
+// tasks of the vc span a few minutes, the tasks completed and gone between
+// two scrapes are missed.
+type taskTracker struct {
+	mux sync.Mutex
+
+	started bool
+	// seen holds the completed tasks still listed as recent.
+	seen      map[string]bool
+	completed map[taskCountKey]float64
+	durations map[string]*taskHistogram
+}
+
+func (s *Session) taskTracker() *taskTracker {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.tasks == nil {
+		s.tasks = &taskTracker{
+			seen:      make(map[string]bool),
+			completed: make(map[taskCountKey]float64),
+			durations: make(map[string]*taskHistogram),
+		}
+	}
+	return s.tasks
+}
+
+// SessionRegistry holds the sessions opened by the exporter.
+type SessionRegistry struct {
+	sessions map[string]*Session
+	mux      sync.Mutex
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Get returns the session for the given url and user, creating it if needed.
+// The session does not log in until its client is first requested.
+func (r *SessionRegistry) Get(logger log.Logger, vcURL string, credentials Credentials, tlsConfig TLSConfig) *Session {
+	key := fmt.Sprintf("%s %+v %+v", vcURL, credentials, tlsConfig)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	s, ok := r.sessions[key]
+	if !ok {
+		s = newSession(logger, vcURL, credentials, tlsConfig)
+		r.sessions[key] = s
+	}
+	return s
+}
+
+// Retain closes and forgets the sessions for which keep returns false.
+func (r *SessionRegistry) Retain(ctx context.Context, keep func(s *Session) bool) {
+	r.mux.Lock()
+	var closed []*Session
+	for key, s := range r.sessions {
+		if !keep(s) {
+			delete(r.sessions, key)
+			closed = append(closed, s)
+		}
+	}
+	r.mux.Unlock()
+	for _, s := range closed {
+		if err := s.Close(ctx); err != nil {
+			level.Warn(s.logger).Log("msg", "unable to close session", "err", err)
+		}
+	}
+}
+
+func newSession(logger log.Logger, vcURL string, credentials Credentials, tlsConfig TLSConfig) *Session {
+	s := &Session{
+		logger:      log.With(logger, "vc", vcURL),
+		url:         vcURL,
+		tls:         tlsConfig,
+		credentials: newCredentialsLoader(credentials),
+	}
+	s.hierarchy = newHierarchyCache(*hierarchyTTL, func(ctx context.Context) (*Hierarchy, error) {
+		client, err := s.Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return retrieveHierarchy(ctx, client.Client)
+	})
+	return s
+}
+
+// GetSession returns the shared session for the given vc and credentials.
+func GetSession(logger log.Logger, vcURL string, credentials Credentials, tlsConfig TLSConfig) *Session {
+	return sessions.Get(logger, vcURL, credentials, tlsConfig)
+}
+
+// RetainSessions closes the sessions which are neither current nor opened
+// with the credentials of a module of config, so that the targets whose
+// module changed reconnect.
+func RetainSessions(ctx context.Context, config *Config, current ...*Session) {
+	sessions.Retain(ctx, func(s *Session) bool {
+		for _, c := range current {
+			if s == c {
+				return true
+			}
+		}
+		for _, m := range config.Modules {
+			if s.credentials.credentials == m.Credentials() && s.tls == m.TLSConfig {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// DefaultSession returns the session for the vc configured on the command
+// line, or nil if none is configured.
+func DefaultSession(logger log.Logger) *Session {
+	if *vcURL == "" {
 		return nil
 	}
+	return sessions.Get(logger, *vcURL, defaultCredentials(), defaultTLSConfig())
+}
+
+// Client returns the authenticated client, logging in on first use or when
+// the credentials changed.
+func (s *Session) Client(ctx context.Context) (*govmomi.Client, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
-	var entity mo.ManagedEntity
-	pc := property.DefaultCollector(client)
-	err := pc.RetrieveOne(ctx, *me.ResourcePool, []string{"name", "parent"}, &entity)
+	changed, err := s.credentials.load()
 	if err != nil {
-		return nil
+		level.Error(s.logger).Log("msg", "unable to load credentials", "err", err)
+	}
+	if changed && s.client != nil {
+		level.Info(s.logger).Log("msg", "credentials changed, logging in again")
+		if err := s.client.Logout(ctx); err != nil {
+			level.Debug(s.logger).Log("msg", "logout error", "err", err)
+		}
+		s.client = nil
+	}
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	level.Debug(s.logger).Log("msg", "connecting to", "url", s.url)
+	u, err := soap.ParseURL(s.url)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "unable to parse url", "url", s.url, "err", err)
+		return nil, err
+	}
+	tlsConfig, err := s.tls.Build()
+	if err != nil {
+		level.Error(s.logger).Log("msg", "invalid tls configuration", "err", err)
+		return nil, err
+	}
+	// The certificate is verified by tlsConfig, not by the soap client.
+	soapClient := soap.NewClient(u, true)
+	soapClient.DefaultTransport().TLSClientConfig = tlsConfig
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		s.loginFailures++
+		if isTLSVerifyError(err) {
+			s.tlsFailures++
+			level.Error(s.logger).Log("msg", "certificate verification failed", "err", err)
+		}
+		return nil, err
+	}
+	vimClient.RoundTripper = keepalive.NewHandlerSOAP(&reloginRoundTripper{session: s, rt: soapClient}, *vcKeepAlive, nil)
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
 	}
-	return &entity
+	err = client.Login(ctx, s.userinfo())
+	if err != nil {
+		s.loginFailures++
+		level.Error(s.logger).Log("msg", "login error", "err", err)
+		return nil, err
+	}
+	s.client = client
+	s.loginTime = time.Now()
+	return s.client, nil
+}
+
+func (s *Session) userinfo() *url.Userinfo {
+	return url.UserPassword(s.credentials.username, s.credentials.password)
 }
 
-func getVMHostSystem(ctx context.Context, logger log.Logger, client *vim25.Client, me mo.VirtualMachine) *mo.ManagedEntity {
-	if me.Summary.Runtime.Host == nil {
+// relogin re-authenticates the session unless another request already did
+// it since the failed request was sent.
+func (s *Session) relogin(ctx context.Context, since time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.client == nil || s.loginTime.After(since) {
 		return nil
 	}
-
-	var entity mo.ManagedEntity
-	pc := property.DefaultCollector(client)
-	err := pc.RetrieveOne(ctx, *me.Summary.Runtime.Host, []string{"name", "parent"}, &entity)
+	level.Info(s.logger).Log("msg", "session not authenticated, logging in again")
+	err := s.client.Login(ctx, s.userinfo())
 	if err != nil {
+		s.loginFailures++
+		level.Error(s.logger).Log("msg", "login error", "err", err)
+		return err
+	}
+	s.relogins++
+	s.loginTime = time.Now()
+	return nil
+}
+
+// Inventory returns the incremental inventory of the session, starting it
+// on first use.
+func (s *Session) Inventory() *Inventory {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.inventory == nil {
+		s.inventory = newInventory(s)
+	}
+	return s.inventory
+}
+
+// Logout closes the session if it has been opened.
+func (s *Session) Logout(ctx context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.client == nil {
 		return nil
 	}
-	return &entity
+	err := s.client.Logout(ctx)
+	s.client = nil
+	return err
 }
 
-func b2f(val bool) float64 {
-	if val {
-		return 1.0
+// Close stops the inventory of the session and logs out.
+func (s *Session) Close(ctx context.Context) error {
+	s.mux.Lock()
+	inventory := s.inventory
+	s.inventory = nil
+	s.mux.Unlock()
+	if inventory != nil {
+		inventory.Stop()
 	}
-	return 0.0
+	return s.Logout(ctx)
 }
 
-type vcCollector struct {
-	logger log.Logger
-	ctx    context.Context
-	client *govmomi.Client
+// Collect exposes the session metrics.
+func (s *Session) Collect(ch chan<- prometheus.Metric) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var age float64
+	if s.client != nil {
+		age = time.Since(s.loginTime).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(sessionAgeDesc, prometheus.GaugeValue, age, s.url)
+	ch <- prometheus.MustNewConstMetric(sessionReloginDesc, prometheus.CounterValue, float64(s.relogins), s.url)
+	ch <- prometheus.MustNewConstMetric(sessionLoginFailuresDesc, prometheus.CounterValue, float64(s.loginFailures), s.url)
+	ch <- prometheus.MustNewConstMetric(sessionTLSFailuresDesc, prometheus.CounterValue, float64(s.tlsFailures), s.url)
+	s.credentials.collect(ch, s.url)
+	if s.inventory != nil {
+		s.inventory.Collect(ch)
+	}
 }
 
-func (c *vcCollector) apiConnect() error {
-	esxURL := *vcURL
-	level.Debug(c.logger).Log("msg", "connecting to", "url", esxURL)
-	u, err := soap.ParseURL(esxURL)
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to parse url", "url", esxURL, "err", err)
+// reloginRoundTripper logs in again and retries the request once when vc
+// reports the session as not authenticated (expired or terminated).
+type reloginRoundTripper struct {
+	session *Session
+	rt      soap.RoundTripper
+}
+
+func (r *reloginRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	begin := time.Now()
+	err := r.rt.RoundTrip(ctx, req, res)
+	if err == nil || !isNotAuthenticated(err) {
 		return err
 	}
-	u.User = url.UserPassword(*vcUsername, *vcPassword)
-	c.ctx = context.Background()
-	c.client, err = govmomi.NewClient(c.ctx, u, true)
-	return err
+	switch req.(type) {
+	case *methods.LoginBody, *methods.LogoutBody:
+		return err
+	}
+	if lerr := r.session.relogin(ctx, begin); lerr != nil {
+		return err
+	}
+	// Reset the response body, it still holds the fault of the first attempt.
+	v := reflect.ValueOf(res).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return r.rt.RoundTrip(ctx, req, res)
+}
+
+func isNotAuthenticated(err error) bool {
+	if soap.IsSoapFault(err) {
+		switch soap.ToSoapFault(err).VimFault().(type) {
+		case types.NotAuthenticated:
+			return true
+		}
+	}
+	if soap.IsVimFault(err) {
+		switch soap.ToVimFault(err).(type) {
+		case *types.NotAuthenticated:
+			return true
+		}
+	}
+	return false
+}
+
+func isManagedObjectNotFound(err error) bool {
+	if soap.IsSoapFault(err) {
+		switch soap.ToSoapFault(err).VimFault().(type) {
+		case types.ManagedObjectNotFound:
+			return true
+		}
+	}
+	if soap.IsVimFault(err) {
+		switch soap.ToVimFault(err).(type) {
+		case *types.ManagedObjectNotFound:
+			return true
+		}
+	}
+	return false
 }
 
-func (c *vcCollector) apiDisconnect() {
-	err := c.client.Logout(c.ctx)
+type vcCollector struct {
+	logger  log.Logger
+	session *Session
+	// properties are the optional properties retrieved in addition to the
+	// ones the collector always needs.
+	properties []string
+	filter     FilterConfig
+}
+
+func newVCCollector(logger log.Logger, session *Session, config *Config, collector string) vcCollector {
+	cfg := config.collectorConfig(collector)
+	return vcCollector{
+		logger:     logger,
+		session:    session,
+		properties: cfg.Properties,
+		filter:     cfg.Filters,
+	}
+}
+
+// hasProperty reports whether the optional property p is retrieved.
+func (c *vcCollector) hasProperty(p string) bool {
+	return containsString(c.properties, p)
+}
+
+// retrieve loads the given properties, along with the optional ones, of
+// every object of kind into dst, from the incremental inventory when
+// enabled.
+func (c *vcCollector) retrieve(scrape *Scrape, kind string, ps []string, dst interface{}) error {
+	ps = append(append([]string{}, ps...), c.properties...)
+	if *useIncrementalInventory {
+		ok, err := c.session.Inventory().Retrieve(kind, ps, dst)
+		if ok || err != nil {
+			return err
+		}
+		level.Debug(c.logger).Log("msg", "inventory not synchronized, retrieving from vc", "kind", kind)
+	}
+
+	m := view.NewManager(scrape.client.Client)
+	v, err := m.CreateContainerView(
+		scrape.ctx,
+		scrape.client.ServiceContent.RootFolder,
+		[]string{kind},
+		true,
+	)
 	if err != nil {
-		level.Error(c.logger).Log("msg", "logout error", "err", err)
+		return err
 	}
-	c.ctx.Done()
+	defer c.destroyView(scrape, v)
+
+	return v.Retrieve(scrape.ctx, []string{kind}, ps, dst)
 }
 
-func (c *vcCollector) destroyView(v *view.ContainerView) {
-	err := v.Destroy(c.ctx)
+func (c *vcCollector) destroyView(scrape *Scrape, v *view.ContainerView) {
+	err := v.Destroy(scrape.ctx)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "logout error", "err", err)
 	}
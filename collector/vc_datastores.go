@@ -19,7 +19,6 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 )
 
@@ -39,7 +38,7 @@ func init() {
 }
 
 // NewDatastoreCollector returns a new Collector exposing IpTables stats.
-func NewDatastoreCollector(logger log.Logger) (Collector, error) {
+func NewDatastoreCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
 	labels := []string{"vc", "dc", "name", "type", "cluster", "maintenance_mode"}
 
 	res := datastoreCollector{
@@ -53,34 +52,29 @@ func NewDatastoreCollector(logger log.Logger) (Collector, error) {
 			prometheus.BuildFQName(namespace, datastoreCollectorSubsystem, "accessible"),
 			"datastore is accessible", labels, nil), prometheus.GaugeValue},
 	}
-	res.logger = logger
+	res.vcCollector = newVCCollector(logger, session, config, datastoreCollectorSubsystem)
 	return &res, nil
 }
 
-func (c *datastoreCollector) Update(ch chan<- prometheus.Metric) (err error) {
+func (c *datastoreCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
 
-	cache.Flush()
-
-	err = c.apiConnect()
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to connect", "err", err)
-		return err
-	}
-	defer c.apiDisconnect()
-	items, err := c.apiRetrieve()
+	items, err := c.apiRetrieve(scrape)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "unable retrieve esx", "err", err)
 		return err
 	}
 
-	vc := *vcURL
+	vc := c.session.url
 
 	level.Debug(c.logger).Log("msg", "datastore retrieved", "num", len(items))
 
 	for _, item := range items {
 		summary := item.Summary
 		name := summary.Name
-		tmp := getParents(c.ctx, c.logger, c.client.Client, item.ManagedEntity)
+		if !c.filter.Match(name) {
+			continue
+		}
+		tmp := scrape.hierarchy.Parents(item.Parent)
 
 		labels := []string{vc, tmp.dc, name, summary.Type, tmp.spod, summary.MaintenanceMode}
 		ch <- c.capacity.mustNewConstMetric(float64(summary.Capacity), labels...)
@@ -91,24 +85,12 @@ func (c *datastoreCollector) Update(ch chan<- prometheus.Metric) (err error) {
 	return nil
 }
 
-func (c *datastoreCollector) apiRetrieve() ([]mo.Datastore, error) {
+func (c *datastoreCollector) apiRetrieve(scrape *Scrape) ([]mo.Datastore, error) {
 	var items []mo.Datastore
 
-	m := view.NewManager(c.client.Client)
-	v, err := m.CreateContainerView(
-		c.ctx,
-		c.client.ServiceContent.RootFolder,
-		[]string{"Datastore"},
-		true,
-	)
-	if err != nil {
-		return items, err
-	}
-	defer c.destroyView(v)
-
-	err = v.Retrieve(
-		c.ctx,
-		[]string{"Datastore"},
+	err := c.retrieve(
+		scrape,
+		"Datastore",
 		[]string{
 			"parent",
 			"summary",
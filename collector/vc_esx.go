@@ -16,11 +16,14 @@
 package collector
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 type esxCollector struct {
@@ -32,20 +35,55 @@ type esxCollector struct {
 	usedCPUMhz     typedDesc
 	availMemBytes  typedDesc
 	usedMemBytes   typedDesc
+	// runtime
+	connectionState typedDesc
+	powerState      typedDesc
+	standbyMode     typedDesc
+	maintenanceMode typedDesc
+	quarantineMode  typedDesc
+	bootTime        typedDesc
+	hardwareInfo    typedDesc
 }
 
+var (
+	esxConnectionStates = []types.HostSystemConnectionState{
+		types.HostSystemConnectionStateConnected,
+		types.HostSystemConnectionStateNotResponding,
+		types.HostSystemConnectionStateDisconnected,
+	}
+	esxPowerStates = []types.HostSystemPowerState{
+		types.HostSystemPowerStatePoweredOn,
+		types.HostSystemPowerStatePoweredOff,
+		types.HostSystemPowerStateStandBy,
+		types.HostSystemPowerStateUnknown,
+	}
+	esxStandbyModes = []types.HostStandbyMode{
+		types.HostStandbyModeEntering,
+		types.HostStandbyModeExiting,
+		types.HostStandbyModeIn,
+		types.HostStandbyModeNone,
+	}
+)
+
 const (
 	esxCollectorSubsystem = "esx"
 )
 
 func init() {
 	registerCollector(esxCollectorSubsystem, defaultEnabled, NewEsxCollector)
+	registerProperties(esxCollectorSubsystem, []string{
+		"hardware.biosInfo",
+		"hardware.systemInfo",
+	})
 }
 
 // NewEsxCollector returns a new Collector exposing IpTables stats.
-func NewEsxCollector(logger log.Logger) (Collector, error) {
+func NewEsxCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
 
 	labels := []string{"vc", "dc", "cluster", "name", "version", "status"}
+	// the runtime state is labelled without the version and status which
+	// change along with it.
+	stateLabels := []string{"vc", "dc", "cluster", "name"}
 
 	res := esxCollector{
 		uptimeSeconds: typedDesc{prometheus.NewDesc(
@@ -69,29 +107,47 @@ func NewEsxCollector(logger log.Logger) (Collector, error) {
 		usedMemBytes: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "used_mem_bytes"),
 			"esx used memory in bytes", labels, nil), prometheus.GaugeValue},
+		connectionState: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "connection_state"),
+			"esx connection state to the vc, 1 for the current state", []string{"vc", "dc", "cluster", "name", "state"}, nil), prometheus.GaugeValue},
+		powerState: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "power_state"),
+			"esx power state, 1 for the current state", []string{"vc", "dc", "cluster", "name", "state"}, nil), prometheus.GaugeValue},
+		standbyMode: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "standby_mode"),
+			"esx standby mode, 1 for the current mode", []string{"vc", "dc", "cluster", "name", "mode"}, nil), prometheus.GaugeValue},
+		maintenanceMode: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "maintenance_mode"),
+			"esx in maintenance mode", stateLabels, nil), prometheus.GaugeValue},
+		quarantineMode: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "quarantine_mode"),
+			"esx in quarantine mode", stateLabels, nil), prometheus.GaugeValue},
+		bootTime: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "boot_timestamp_seconds"),
+			"esx boot time since unix epoch in seconds", stateLabels, nil), prometheus.GaugeValue},
+		hardwareInfo: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxCollectorSubsystem, "hardware_info"),
+			"esx hardware, firmware and build, always 1",
+			[]string{
+				"vc", "dc", "cluster", "name",
+				"vendor", "model", "cpu_model", "cpu_packages", "cpu_threads",
+				"bios_version", "bios_date", "serial_number", "service_tag", "build",
+			}, nil), prometheus.GaugeValue},
 	}
-	res.logger = logger
+	res.vcCollector = newVCCollector(logger, session, config, esxCollectorSubsystem)
 
 	return &res, nil
 }
 
-func (c *esxCollector) Update(ch chan<- prometheus.Metric) (err error) {
-
-	cache.Flush()
+func (c *esxCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
 
-	err = c.apiConnect()
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to connect", "err", err)
-		return err
-	}
-	defer c.apiDisconnect()
-	hss, err := c.apiRetrieve()
+	hss, err := c.apiRetrieve(scrape)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "unable retrieve esx", "err", err)
 		return err
 	}
 
-	vc := *vcURL
+	vc := c.session.url
 
 	level.Debug(c.logger).Log("msg", "esx host retrieved", "num", len(hss))
 
@@ -99,8 +155,11 @@ func (c *esxCollector) Update(ch chan<- prometheus.Metric) (err error) {
 
 		summ := hs.Summary
 		name := summ.Config.Name
+		if !c.filter.Match(name) {
+			continue
+		}
 
-		tmp := getParents(c.ctx, c.logger, c.client.Client, hs.ManagedEntity)
+		tmp := scrape.hierarchy.Parents(hs.Parent)
 		version := summ.Config.Product.Version
 		status := string(summ.OverallStatus)
 		qs := summ.QuickStats
@@ -122,28 +181,125 @@ func (c *esxCollector) Update(ch chan<- prometheus.Metric) (err error) {
 		ch <- c.usedCPUMhz.mustNewConstMetric(float64(qs.OverallCpuUsage), labels...)
 		ch <- c.availMemBytes.mustNewConstMetric(float64(summ.Hardware.MemorySize), labels...)
 		ch <- c.usedMemBytes.mustNewConstMetric(float64(int64(qs.OverallMemoryUsage)*mb), labels...)
+		ch <- c.hardwareInfo.mustNewConstMetric(1, GetEsxHardware(hs).labels(vc, tmp.dc, tmp.cluster, name)...)
+
+		// summary.runtime is the runtime property of the host.
+		rt := summ.Runtime
+		if rt == nil {
+			continue
+		}
+		stateLabels := []string{vc, tmp.dc, tmp.cluster, name}
+		for _, state := range esxConnectionStates {
+			ch <- c.connectionState.mustNewConstMetric(b2f(rt.ConnectionState == state), []string{vc, tmp.dc, tmp.cluster, name, string(state)}...)
+		}
+		for _, state := range esxPowerStates {
+			ch <- c.powerState.mustNewConstMetric(b2f(rt.PowerState == state), []string{vc, tmp.dc, tmp.cluster, name, string(state)}...)
+		}
+		if rt.StandbyMode != "" {
+			for _, mode := range esxStandbyModes {
+				ch <- c.standbyMode.mustNewConstMetric(b2f(rt.StandbyMode == string(mode)), []string{vc, tmp.dc, tmp.cluster, name, string(mode)}...)
+			}
+		}
+		ch <- c.maintenanceMode.mustNewConstMetric(b2f(rt.InMaintenanceMode), stateLabels...)
+		if rt.InQuarantineMode != nil {
+			ch <- c.quarantineMode.mustNewConstMetric(b2f(*rt.InQuarantineMode), stateLabels...)
+		}
+		if rt.BootTime != nil {
+			ch <- c.bootTime.mustNewConstMetric(float64(rt.BootTime.Unix()), stateLabels...)
+		}
 	}
 	return nil
 }
 
-func (c *esxCollector) apiRetrieve() ([]mo.HostSystem, error) {
-	var hss []mo.HostSystem
+type EsxHardware struct {
+	vendor       string
+	model        string
+	cpuModel     string
+	cpuPackages  string
+	cpuThreads   string
+	biosVersion  string
+	biosDate     string
+	serialNumber string
+	serviceTag   string
+	build        string
+}
 
-	m := view.NewManager(c.client.Client)
-	v, err := m.CreateContainerView(
-		c.ctx,
-		c.client.ServiceContent.RootFolder,
-		[]string{"HostSystem"},
-		true,
-	)
-	if err != nil {
-		return hss, err
+func (h EsxHardware) labels(vc, dc, cluster, name string) []string {
+	return []string{
+		vc, dc, cluster, name,
+		h.vendor, h.model, h.cpuModel, h.cpuPackages, h.cpuThreads,
+		h.biosVersion, h.biosDate, h.serialNumber, h.serviceTag, h.build,
+	}
+}
+
+// GetEsxHardware returns the hardware details of the host, the serial number
+// and service tag being read from the identifiers reported by the hardware.
+func GetEsxHardware(hs mo.HostSystem) EsxHardware {
+	res := EsxHardware{
+		vendor:       "NONE",
+		model:        "NONE",
+		cpuModel:     "NONE",
+		cpuPackages:  "NONE",
+		cpuThreads:   "NONE",
+		biosVersion:  "NONE",
+		biosDate:     "NONE",
+		serialNumber: "NONE",
+		serviceTag:   "NONE",
+		build:        "NONE",
+	}
+	if hs.Summary.Config.Product != nil && hs.Summary.Config.Product.Build != "" {
+		res.build = hs.Summary.Config.Product.Build
+	}
+	var identifiers []types.HostSystemIdentificationInfo
+	if hw := hs.Summary.Hardware; hw != nil {
+		res.vendor = hardwareLabel(hw.Vendor)
+		res.model = hardwareLabel(hw.Model)
+		res.cpuModel = hardwareLabel(hw.CpuModel)
+		res.cpuPackages = strconv.Itoa(int(hw.NumCpuPkgs))
+		res.cpuThreads = strconv.Itoa(int(hw.NumCpuThreads))
+		identifiers = hw.OtherIdentifyingInfo
+	}
+	if hs.Hardware != nil {
+		if bios := hs.Hardware.BiosInfo; bios != nil {
+			res.biosVersion = hardwareLabel(bios.BiosVersion)
+			if bios.ReleaseDate != nil {
+				res.biosDate = bios.ReleaseDate.Format("2006-01-02")
+			}
+		}
+		res.serialNumber = hardwareLabel(hs.Hardware.SystemInfo.SerialNumber)
+		identifiers = append(identifiers, hs.Hardware.SystemInfo.OtherIdentifyingInfo...)
 	}
-	defer c.destroyView(v)
+	for _, id := range identifiers {
+		value := hardwareLabel(id.IdentifierValue)
+		if value == "NONE" || id.IdentifierType == nil {
+			continue
+		}
+		switch id.IdentifierType.GetElementDescription().Key {
+		case "SerialNumberTag":
+			res.serialNumber = value
+		case "ServiceTag":
+			res.serviceTag = value
+		}
+	}
+	return res
+}
+
+// hardwareLabel returns a hardware detail without the padding of the SMBIOS
+// strings, NONE if it is empty.
+func hardwareLabel(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "NONE"
+	}
+	return value
+}
+
+func (c *esxCollector) apiRetrieve(scrape *Scrape) ([]mo.HostSystem, error) {
+	var hss []mo.HostSystem
 
-	err = v.Retrieve(
-		c.ctx,
-		[]string{"HostSystem"},
+	err := c.retrieve(
+		scrape,
+		"HostSystem",
 		[]string{
 			"parent",
 			"summary",
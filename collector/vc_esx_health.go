@@ -0,0 +1,148 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"math"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type esxHealthCollector struct {
+	vcCollector
+	sensorReading  typedDesc
+	sensorState    typedDesc
+	hardwareStatus typedDesc
+}
+
+const (
+	esxHealthCollectorSubsystem = "esx_health"
+)
+
+func init() {
+	registerCollector(esxHealthCollectorSubsystem, defaultDisabled, NewEsxHealthCollector)
+}
+
+// NewEsxHealthCollector returns a new Collector exposing the hardware
+// sensors and status of the hosts, as reported by their CIM providers.
+func NewEsxHealthCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	res := esxHealthCollector{
+		sensorReading: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxHealthCollectorSubsystem, "sensor_reading"),
+			"esx numeric sensor reading, in the sensor unit",
+			[]string{"vc", "dc", "cluster", "esx", "sensor", "type", "unit"}, nil), prometheus.GaugeValue},
+		sensorState: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxHealthCollectorSubsystem, "sensor_state"),
+			"esx numeric sensor health state, 1 for the current state",
+			[]string{"vc", "dc", "cluster", "esx", "sensor", "type", "state"}, nil), prometheus.GaugeValue},
+		hardwareStatus: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxHealthCollectorSubsystem, "hardware_status"),
+			"esx memory, cpu and storage element status, 1 for the current status",
+			[]string{"vc", "dc", "cluster", "esx", "kind", "element", "status"}, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, esxHealthCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *esxHealthCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	var hss []mo.HostSystem
+	err = c.retrieve(scrape, "HostSystem", []string{
+		"name",
+		"parent",
+		"runtime.connectionState",
+		"runtime.healthSystemRuntime",
+	}, &hss)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve esx health", "err", err)
+		return err
+	}
+
+	vc := c.session.url
+
+	level.Debug(c.logger).Log("msg", "esx health retrieved", "num", len(hss))
+
+	for _, hs := range hss {
+		if hs.Runtime.ConnectionState != types.HostSystemConnectionStateConnected || !c.filter.Match(hs.Name) {
+			continue
+		}
+		health := hs.Runtime.HealthSystemRuntime
+		if health == nil {
+			continue
+		}
+		parents := scrape.hierarchy.Parents(hs.Parent)
+		labels := []string{vc, parents.dc, parents.cluster, hs.Name}
+
+		if health.SystemHealthInfo != nil {
+			for _, sensor := range health.SystemHealthInfo.NumericSensorInfo {
+				tmp := append(labels, sensor.Name, sensor.SensorType)
+				ch <- c.sensorReading.mustNewConstMetric(sensorValue(sensor), append(tmp, sensorUnit(sensor))...)
+				ch <- c.sensorState.mustNewConstMetric(1, append(tmp, elementKey(sensor.HealthState))...)
+			}
+		}
+
+		status := health.HardwareStatusInfo
+		if status == nil {
+			continue
+		}
+		for _, e := range status.MemoryStatusInfo {
+			info := e.GetHostHardwareElementInfo()
+			ch <- c.hardwareStatus.mustNewConstMetric(1, append(labels, "memory", info.Name, elementKey(info.Status))...)
+		}
+		for _, e := range status.CpuStatusInfo {
+			info := e.GetHostHardwareElementInfo()
+			ch <- c.hardwareStatus.mustNewConstMetric(1, append(labels, "cpu", info.Name, elementKey(info.Status))...)
+		}
+		for _, info := range status.StorageStatusInfo {
+			ch <- c.hardwareStatus.mustNewConstMetric(1, append(labels, "storage", info.Name, elementKey(info.Status))...)
+		}
+	}
+	return nil
+}
+
+// sensorValue returns the reading of a sensor, scaled by its unit modifier:
+// a reading of 2350 with a modifier of -2 is 23.5.
+func sensorValue(sensor types.HostNumericSensorInfo) float64 {
+	return float64(sensor.CurrentReading) * math.Pow10(int(sensor.UnitModifier))
+}
+
+// sensorUnit returns the unit of a sensor, e.g. Degrees C or RPM, followed
+// by its rate unit if any.
+func sensorUnit(sensor types.HostNumericSensorInfo) string {
+	if sensor.BaseUnits == "" {
+		return "NONE"
+	}
+	if sensor.RateUnits == "" || sensor.RateUnits == "none" {
+		return sensor.BaseUnits
+	}
+	return sensor.BaseUnits + "/" + sensor.RateUnits
+}
+
+// elementKey returns the key of a health state, e.g. green or red, NONE if
+// unset.
+func elementKey(desc types.BaseElementDescription) string {
+	if desc == nil {
+		return "NONE"
+	}
+	key := desc.GetElementDescription().Key
+	if key == "" {
+		return "NONE"
+	}
+	return key
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestEsxHealthLabels(t *testing.T) {
+	c, err := NewEsxHealthCollector(log.NewNopLogger(), nil, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := c.(*esxHealthCollector)
+	checkDescLabels(t, health.sensorReading, "vc", "dc", "cluster", "esx", "sensor", "type", "unit")
+	checkDescLabels(t, health.sensorState, "vc", "dc", "cluster", "esx", "sensor", "type", "state")
+	checkDescLabels(t, health.hardwareStatus, "vc", "dc", "cluster", "esx", "kind", "element", "status")
+}
+
+func TestSensorValue(t *testing.T) {
+	for _, test := range []struct {
+		sensor types.HostNumericSensorInfo
+		value  float64
+		unit   string
+	}{
+		{types.HostNumericSensorInfo{CurrentReading: 2350, UnitModifier: -2, BaseUnits: "Degrees C"}, 23.5, "Degrees C"},
+		{types.HostNumericSensorInfo{CurrentReading: 54, UnitModifier: 2, BaseUnits: "RPM", RateUnits: "none"}, 5400, "RPM"},
+		{types.HostNumericSensorInfo{CurrentReading: 120}, 120, "NONE"},
+	} {
+		if value := sensorValue(test.sensor); math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("%+v: want value %v, have %v", test.sensor, test.value, value)
+		}
+		if unit := sensorUnit(test.sensor); unit != test.unit {
+			t.Errorf("%+v: want unit %q, have %q", test.sensor, test.unit, unit)
+		}
+	}
+}
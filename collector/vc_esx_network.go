@@ -0,0 +1,149 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type esxNetworkCollector struct {
+	vcCollector
+	pnicLinkUp         typedDesc
+	pnicLinkSpeed      typedDesc
+	pnicFullDuplex     typedDesc
+	vnicMTU            typedDesc
+	vnicServiceEnabled typedDesc
+}
+
+const (
+	esxNetworkCollectorSubsystem = "esx_network"
+)
+
+func init() {
+	registerCollector(esxNetworkCollectorSubsystem, defaultDisabled, NewEsxNetworkCollector)
+}
+
+// NewEsxNetworkCollector returns a new Collector exposing the link state of
+// the physical nics and the configuration of the vmkernel nics of the hosts.
+func NewEsxNetworkCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	labels := []string{"vc", "dc", "cluster", "esx", "nic"}
+	pnicLabels := append(labels, "driver", "mac")
+	vnicLabels := append(labels, "ip", "mac", "portgroup")
+
+	res := esxNetworkCollector{
+		pnicLinkUp: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxNetworkCollectorSubsystem, "pnic_link_up"),
+			"esx physical nic link up", pnicLabels, nil), prometheus.GaugeValue},
+		pnicLinkSpeed: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxNetworkCollectorSubsystem, "pnic_link_speed_mbps"),
+			"esx physical nic link speed in Mbps", pnicLabels, nil), prometheus.GaugeValue},
+		pnicFullDuplex: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxNetworkCollectorSubsystem, "pnic_full_duplex"),
+			"esx physical nic link in full duplex", pnicLabels, nil), prometheus.GaugeValue},
+		vnicMTU: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxNetworkCollectorSubsystem, "vnic_mtu_bytes"),
+			"esx vmkernel nic mtu in bytes", vnicLabels, nil), prometheus.GaugeValue},
+		vnicServiceEnabled: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxNetworkCollectorSubsystem, "vnic_service_enabled"),
+			"esx vmkernel nic enabled for the service, e.g. vmotion or management", append(labels, "service"), nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, esxNetworkCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *esxNetworkCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	var hss []mo.HostSystem
+	err = c.retrieve(scrape, "HostSystem", []string{
+		"name",
+		"parent",
+		"runtime.connectionState",
+		"config.network.pnic",
+		"config.network.vnic",
+		"config.virtualNicManagerInfo.netConfig",
+	}, &hss)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve esx network", "err", err)
+		return err
+	}
+
+	vc := c.session.url
+
+	level.Debug(c.logger).Log("msg", "esx network retrieved", "num", len(hss))
+
+	for _, hs := range hss {
+		if hs.Runtime.ConnectionState != types.HostSystemConnectionStateConnected || !c.filter.Match(hs.Name) {
+			continue
+		}
+		if hs.Config == nil {
+			continue
+		}
+		parents := scrape.hierarchy.Parents(hs.Parent)
+
+		if network := hs.Config.Network; network != nil {
+			for _, pnic := range network.Pnic {
+				labels := []string{vc, parents.dc, parents.cluster, hs.Name, pnic.Device, pnic.Driver, pnic.Mac}
+				ch <- c.pnicLinkUp.mustNewConstMetric(b2f(pnic.LinkSpeed != nil), labels...)
+				if pnic.LinkSpeed != nil {
+					ch <- c.pnicLinkSpeed.mustNewConstMetric(float64(pnic.LinkSpeed.SpeedMb), labels...)
+					ch <- c.pnicFullDuplex.mustNewConstMetric(b2f(pnic.LinkSpeed.Duplex), labels...)
+				}
+			}
+			for _, vnic := range network.Vnic {
+				ip := "NONE"
+				if vnic.Spec.Ip != nil && vnic.Spec.Ip.IpAddress != "" {
+					ip = vnic.Spec.Ip.IpAddress
+				}
+				labels := []string{vc, parents.dc, parents.cluster, hs.Name, vnic.Device, ip, vnic.Spec.Mac, vnicPortgroup(scrape, vnic)}
+				ch <- c.vnicMTU.mustNewConstMetric(float64(vnic.Spec.Mtu), labels...)
+			}
+		}
+
+		if info := hs.Config.VirtualNicManagerInfo; info != nil {
+			for _, config := range info.NetConfig {
+				selected := make(map[string]bool, len(config.SelectedVnic))
+				for _, key := range config.SelectedVnic {
+					selected[key] = true
+				}
+				for _, vnic := range config.CandidateVnic {
+					labels := []string{vc, parents.dc, parents.cluster, hs.Name, vnic.Device, config.NicType}
+					ch <- c.vnicServiceEnabled.mustNewConstMetric(b2f(selected[vnic.Key]), labels...)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// vnicPortgroup returns the standard portgroup of a vmkernel nic, or the
+// name of its distributed portgroup.
+func vnicPortgroup(scrape *Scrape, vnic types.HostVirtualNic) string {
+	if vnic.Portgroup != "" {
+		return vnic.Portgroup
+	}
+	port := vnic.Spec.DistributedVirtualPort
+	if port == nil || port.PortgroupKey == "" {
+		return "NONE"
+	}
+	ref := types.ManagedObjectReference{Type: "DistributedVirtualPortgroup", Value: port.PortgroupKey}
+	if name := scrape.hierarchy.Name(&ref); name != "NONE" {
+		return name
+	}
+	return port.PortgroupKey
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestEsxNetworkCollector(t *testing.T) {
+	c, err := NewEsxNetworkCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectSimulator(t, c)
+
+	for series, value := range map[string]float64{
+		`govc_esx_network_pnic_link_up{cluster="NONE",dc="DC0",driver="nvmxnet3",esx="DC0_H0",mac="00:0c:29:81:d8:a0",nic="vmnic0",vc="vcsim"}`:                              1,
+		`govc_esx_network_pnic_link_speed_mbps{cluster="NONE",dc="DC0",driver="nvmxnet3",esx="DC0_H0",mac="00:0c:29:81:d8:a0",nic="vmnic0",vc="vcsim"}`:                      10000,
+		`govc_esx_network_pnic_full_duplex{cluster="NONE",dc="DC0",driver="nvmxnet3",esx="DC0_H0",mac="00:0c:29:81:d8:aa",nic="vmnic1",vc="vcsim"}`:                          1,
+		`govc_esx_network_pnic_link_up{cluster="DC0_C0",dc="DC0",driver="nvmxnet3",esx="DC0_C0_H0",mac="00:0c:29:81:d8:a0",nic="vmnic0",vc="vcsim"}`:                         1,
+		`govc_esx_network_vnic_mtu_bytes{cluster="NONE",dc="DC0",esx="DC0_H0",ip="127.0.0.1",mac="00:0c:29:81:d8:a0",nic="vmk0",portgroup="Management Network",vc="vcsim"}`: 1500,
+		`govc_esx_network_vnic_service_enabled{cluster="NONE",dc="DC0",esx="DC0_H0",nic="vmk0",service="management",vc="vcsim"}`:                                             1,
+		`govc_esx_network_vnic_service_enabled{cluster="NONE",dc="DC0",esx="DC0_H0",nic="vmk1",service="management",vc="vcsim"}`:                                             0,
+		`govc_esx_network_vnic_service_enabled{cluster="NONE",dc="DC0",esx="DC0_H0",nic="vmk0",service="vmotion",vc="vcsim"}`:                                                0,
+	} {
+		have, ok := metrics[series]
+		if !ok {
+			t.Errorf("missing %s", series)
+			continue
+		}
+		if have != value {
+			t.Errorf("%s: want %v, have %v", series, value, have)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type esxStorageCollector struct {
+	vcCollector
+	lunPaths            typedDesc
+	lunOperationalState typedDesc
+	hbaStatus           typedDesc
+	hbaLinkSpeed        typedDesc
+}
+
+const (
+	esxStorageCollectorSubsystem = "esx_storage"
+)
+
+// multipathStates are the states of the paths to a lun, counted even when
+// no path is in that state.
+var multipathStates = []types.MultipathState{
+	types.MultipathStateActive,
+	types.MultipathStateStandby,
+	types.MultipathStateDisabled,
+	types.MultipathStateDead,
+	types.MultipathStateUnknown,
+}
+
+func init() {
+	registerCollector(esxStorageCollectorSubsystem, defaultDisabled, NewEsxStorageCollector)
+}
+
+// NewEsxStorageCollector returns a new Collector exposing the storage
+// adapters, luns and multipathing of the hosts.
+func NewEsxStorageCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	lunLabels := []string{"vc", "dc", "cluster", "esx", "lun", "datastore", "state"}
+	hbaLabels := []string{"vc", "dc", "cluster", "esx", "hba", "type", "model", "driver"}
+
+	res := esxStorageCollector{
+		lunPaths: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxStorageCollectorSubsystem, "lun_paths"),
+			"esx number of paths to the lun by state", lunLabels, nil), prometheus.GaugeValue},
+		lunOperationalState: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxStorageCollectorSubsystem, "lun_operational_state"),
+			"esx lun operational state, 1 for the current states", lunLabels, nil), prometheus.GaugeValue},
+		hbaStatus: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxStorageCollectorSubsystem, "hba_status"),
+			"esx storage adapter status, 1 for the current status", []string{"vc", "dc", "cluster", "esx", "hba", "type", "model", "driver", "status"}, nil), prometheus.GaugeValue},
+		hbaLinkSpeed: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, esxStorageCollectorSubsystem, "hba_link_speed_bits_per_second"),
+			"esx fibre channel adapter link speed in bits per second", hbaLabels, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, esxStorageCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *esxStorageCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	var hss []mo.HostSystem
+	err = c.retrieve(scrape, "HostSystem", []string{
+		"name",
+		"parent",
+		"runtime.connectionState",
+		"config.storageDevice.hostBusAdapter",
+		"config.storageDevice.scsiLun",
+		"config.storageDevice.multipathInfo",
+		"config.fileSystemVolume.mountInfo",
+	}, &hss)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve esx storage", "err", err)
+		return err
+	}
+
+	vc := c.session.url
+
+	level.Debug(c.logger).Log("msg", "esx storage retrieved", "num", len(hss))
+
+	for _, hs := range hss {
+		if hs.Runtime.ConnectionState != types.HostSystemConnectionStateConnected || !c.filter.Match(hs.Name) {
+			continue
+		}
+		if hs.Config == nil || hs.Config.StorageDevice == nil {
+			continue
+		}
+		parents := scrape.hierarchy.Parents(hs.Parent)
+		device := hs.Config.StorageDevice
+
+		for _, base := range device.HostBusAdapter {
+			hba := base.GetHostHostBusAdapter()
+			kind := hbaType(base)
+			ch <- c.hbaStatus.mustNewConstMetric(1, vc, parents.dc, parents.cluster, hs.Name, hba.Device, kind, hba.Model, hba.Driver, hba.Status)
+			if fc, ok := base.(*types.HostFibreChannelHba); ok {
+				// the speed of a fibre channel adapter is in bits per second.
+				ch <- c.hbaLinkSpeed.mustNewConstMetric(float64(fc.Speed), vc, parents.dc, parents.cluster, hs.Name, hba.Device, kind, hba.Model, hba.Driver)
+			}
+		}
+
+		var datastores map[string]string
+		if hs.Config.FileSystemVolume != nil {
+			datastores = lunDatastores(hs.Config.FileSystemVolume.MountInfo)
+		}
+		luns := make(map[string]*types.ScsiLun, len(device.ScsiLun))
+		for _, base := range device.ScsiLun {
+			lun := base.GetScsiLun()
+			luns[lun.Key] = lun
+			datastore := datastoreNames(datastores, lun.CanonicalName)
+			for _, state := range lun.OperationalState {
+				ch <- c.lunOperationalState.mustNewConstMetric(1, vc, parents.dc, parents.cluster, hs.Name, lun.CanonicalName, datastore, state)
+			}
+		}
+
+		if device.MultipathInfo == nil {
+			continue
+		}
+		for _, mp := range device.MultipathInfo.Lun {
+			name := mp.Id
+			if lun, ok := luns[mp.Lun]; ok {
+				name = lun.CanonicalName
+			}
+			datastore := datastoreNames(datastores, name)
+			counts := pathStateCounts(mp.Path)
+			for _, state := range multipathStates {
+				ch <- c.lunPaths.mustNewConstMetric(counts[string(state)], vc, parents.dc, parents.cluster, hs.Name, name, datastore, string(state))
+			}
+		}
+	}
+	return nil
+}
+
+// hbaType returns the kind of a storage adapter, e.g. FibreChannel,
+// InternetScsi or BlockHba.
+func hbaType(hba types.BaseHostHostBusAdapter) string {
+	return strings.TrimSuffix(strings.TrimPrefix(reflect.TypeOf(hba).Elem().Name(), "Host"), "Hba")
+}
+
+// pathStateCounts returns the number of paths by state.
+func pathStateCounts(paths []types.HostMultipathInfoPath) map[string]float64 {
+	res := make(map[string]float64, len(multipathStates))
+	for _, path := range paths {
+		res[path.PathState]++
+	}
+	return res
+}
+
+// lunDatastores returns the names of the vmfs datastores by canonical name
+// of the luns holding their extents, comma separated.
+func lunDatastores(mounts []types.HostFileSystemMountInfo) map[string]string {
+	names := make(map[string][]string)
+	for _, mount := range mounts {
+		vmfs, ok := mount.Volume.(*types.HostVmfsVolume)
+		if !ok {
+			continue
+		}
+		for _, extent := range vmfs.Extent {
+			if !containsString(names[extent.DiskName], vmfs.Name) {
+				names[extent.DiskName] = append(names[extent.DiskName], vmfs.Name)
+			}
+		}
+	}
+	res := make(map[string]string, len(names))
+	for lun, datastores := range names {
+		sort.Strings(datastores)
+		res[lun] = strings.Join(datastores, ",")
+	}
+	return res
+}
+
+func datastoreNames(datastores map[string]string, lun string) string {
+	if name, ok := datastores[lun]; ok {
+		return name
+	}
+	return "NONE"
+}
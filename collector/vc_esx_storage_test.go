@@ -0,0 +1,101 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestEsxStorageLabels(t *testing.T) {
+	c, err := NewEsxStorageCollector(log.NewNopLogger(), nil, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := c.(*esxStorageCollector)
+	checkDescLabels(t, storage.lunPaths, "vc", "dc", "cluster", "esx", "lun", "datastore", "state")
+	checkDescLabels(t, storage.lunOperationalState, "vc", "dc", "cluster", "esx", "lun", "datastore", "state")
+	checkDescLabels(t, storage.hbaStatus, "vc", "dc", "cluster", "esx", "hba", "type", "model", "driver", "status")
+	checkDescLabels(t, storage.hbaLinkSpeed, "vc", "dc", "cluster", "esx", "hba", "type", "model", "driver")
+}
+
+func TestLunDatastores(t *testing.T) {
+	vmfs := func(name string, disks ...string) types.HostFileSystemMountInfo {
+		volume := &types.HostVmfsVolume{HostFileSystemVolume: types.HostFileSystemVolume{Name: name}}
+		for _, disk := range disks {
+			volume.Extent = append(volume.Extent, types.HostScsiDiskPartition{DiskName: disk, Partition: 1})
+		}
+		return types.HostFileSystemMountInfo{Volume: volume}
+	}
+	datastores := lunDatastores([]types.HostFileSystemMountInfo{
+		vmfs("ds2", "naa.1", "naa.2"),
+		vmfs("ds1", "naa.1"),
+		{Volume: &types.HostNasVolume{HostFileSystemVolume: types.HostFileSystemVolume{Name: "nfs"}}},
+	})
+	for lun, want := range map[string]string{"naa.1": "ds1,ds2", "naa.2": "ds2", "naa.3": "NONE"} {
+		if have := datastoreNames(datastores, lun); have != want {
+			t.Errorf("%s: want datastores %q, have %q", lun, want, have)
+		}
+	}
+}
+
+func TestPathStateCounts(t *testing.T) {
+	counts := pathStateCounts([]types.HostMultipathInfoPath{
+		{Name: "vmhba1:C0:T0:L1", PathState: "active"},
+		{Name: "vmhba1:C0:T1:L1", PathState: "active"},
+		{Name: "vmhba2:C0:T0:L1", PathState: "dead"},
+	})
+	if counts["active"] != 2 || counts["dead"] != 1 || counts["standby"] != 0 {
+		t.Errorf("unexpected path counts %v", counts)
+	}
+}
+
+func TestEsxStorageCollector(t *testing.T) {
+	c, err := NewEsxStorageCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectSimulator(t, c, func(ctx context.Context, vc *vim25.Client) {
+		for _, e := range simulator.Map.All("HostSystem") {
+			host := e.(*simulator.HostSystem)
+			if host.Name != "DC0_H0" {
+				continue
+			}
+			// the hosts share the storage devices of the vcsim model.
+			config := *host.Config
+			device := *config.StorageDevice
+			device.HostBusAdapter = append([]types.BaseHostHostBusAdapter{&types.HostFibreChannelHba{
+				HostHostBusAdapter: types.HostHostBusAdapter{Device: "vmhba2", Model: "QLE2692", Driver: "qlnativefc", Status: "online"},
+				Speed:              16000000000,
+			}}, device.HostBusAdapter...)
+			config.StorageDevice = &device
+			host.Config = &config
+		}
+	})
+	series := `govc_esx_storage_hba_link_speed_bits_per_second{cluster="NONE",dc="DC0",driver="qlnativefc",esx="DC0_H0",hba="vmhba2",model="QLE2692",type="FibreChannel",vc="vcsim"}`
+	if have, ok := metrics[series]; !ok || have != 16e9 {
+		t.Errorf("%s: want 16e9, have %v", series, have)
+	}
+	status := `govc_esx_storage_hba_status{cluster="NONE",dc="DC0",driver="qlnativefc",esx="DC0_H0",hba="vmhba2",model="QLE2692",status="online",type="FibreChannel",vc="vcsim"}`
+	if _, ok := metrics[status]; !ok {
+		t.Errorf("missing %s", status)
+	}
+}
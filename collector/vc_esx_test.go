@@ -0,0 +1,138 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestGetEsxHardware(t *testing.T) {
+	identifier := func(kind, value string) types.HostSystemIdentificationInfo {
+		return types.HostSystemIdentificationInfo{
+			IdentifierValue: value,
+			IdentifierType:  &types.ElementDescription{Key: kind},
+		}
+	}
+	released := time.Date(2020, 3, 12, 0, 0, 0, 0, time.UTC)
+	hs := mo.HostSystem{
+		Summary: types.HostListSummary{
+			Hardware: &types.HostHardwareSummary{
+				Vendor:        "Dell Inc.",
+				Model:         "PowerEdge R640",
+				CpuModel:      "Intel(R) Xeon(R) Gold 6130 CPU @ 2.10GHz",
+				NumCpuPkgs:    2,
+				NumCpuThreads: 64,
+				OtherIdentifyingInfo: []types.HostSystemIdentificationInfo{
+					identifier("AssetTag", "unknown"),
+					identifier("ServiceTag", "7XK2Q13"),
+					identifier("SerialNumberTag", "CN7016381U0045"),
+				},
+			},
+			Config: types.HostConfigSummary{Product: &types.AboutInfo{Build: "16850804"}},
+		},
+		Hardware: &types.HostHardwareInfo{
+			BiosInfo: &types.HostBIOSInfo{BiosVersion: "2.6.3", ReleaseDate: &released},
+		},
+	}
+	have := GetEsxHardware(hs).labels("vc", "dc", "cluster", "esx1")
+	want := []string{
+		"vc", "dc", "cluster", "esx1",
+		"Dell Inc.", "PowerEdge R640", "Intel(R) Xeon(R) Gold 6130 CPU @ 2.10GHz", "2", "64",
+		"2.6.3", "2020-03-12", "CN7016381U0045", "7XK2Q13", "16850804",
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("label %d: want %q, have %q", i, want[i], have[i])
+		}
+	}
+}
+
+func TestGetEsxHardwareEmpty(t *testing.T) {
+	for _, hs := range []mo.HostSystem{
+		{},
+		{
+			Summary: types.HostListSummary{
+				Hardware: &types.HostHardwareSummary{
+					Vendor: "  ",
+					OtherIdentifyingInfo: []types.HostSystemIdentificationInfo{
+						{IdentifierValue: " ", IdentifierType: &types.ElementDescription{Key: "ServiceTag"}},
+					},
+				},
+				Config: types.HostConfigSummary{Product: &types.AboutInfo{}},
+			},
+			Hardware: &types.HostHardwareInfo{BiosInfo: &types.HostBIOSInfo{}},
+		},
+	} {
+		have := GetEsxHardware(hs).labels("vc", "dc", "cluster", "esx1")
+		for i, label := range have {
+			if i == 7 || i == 8 {
+				// the cpu packages and threads are counts
+				continue
+			}
+			if i >= 4 && label != "NONE" {
+				t.Errorf("%+v: label %d: want NONE, have %q", hs.Summary.Hardware, i, label)
+			}
+		}
+	}
+}
+
+func TestEsxStateLabels(t *testing.T) {
+	c, err := NewEsxCollector(log.NewNopLogger(), nil, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	esx := c.(*esxCollector)
+	checkDescLabels(t, esx.uptimeSeconds, "vc", "dc", "cluster", "name", "version", "status")
+	checkDescLabels(t, esx.connectionState, "vc", "dc", "cluster", "name", "state")
+	checkDescLabels(t, esx.powerState, "vc", "dc", "cluster", "name", "state")
+	checkDescLabels(t, esx.standbyMode, "vc", "dc", "cluster", "name", "mode")
+	checkDescLabels(t, esx.maintenanceMode, "vc", "dc", "cluster", "name")
+	checkDescLabels(t, esx.quarantineMode, "vc", "dc", "cluster", "name")
+	checkDescLabels(t, esx.bootTime, "vc", "dc", "cluster", "name")
+}
+
+func TestEsxCollector(t *testing.T) {
+	c, err := NewEsxCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectSimulator(t, c)
+	for series, want := range map[string]float64{
+		`govc_esx_connection_state{cluster="NONE",dc="DC0",name="DC0_H0",state="connected",vc="vcsim"}`:      1,
+		`govc_esx_connection_state{cluster="NONE",dc="DC0",name="DC0_H0",state="disconnected",vc="vcsim"}`:   0,
+		`govc_esx_power_state{cluster="NONE",dc="DC0",name="DC0_H0",state="poweredOn",vc="vcsim"}`:           1,
+		`govc_esx_power_state{cluster="NONE",dc="DC0",name="DC0_H0",state="standBy",vc="vcsim"}`:             0,
+		`govc_esx_maintenance_mode{cluster="NONE",dc="DC0",name="DC0_H0",vc="vcsim"}`:                        0,
+		`govc_esx_connection_state{cluster="DC0_C0",dc="DC0",name="DC0_C0_H2",state="connected",vc="vcsim"}`: 1,
+	} {
+		have, ok := metrics[series]
+		if !ok {
+			t.Errorf("missing %s", series)
+			continue
+		}
+		if have != want {
+			t.Errorf("%s: want %v, have %v", series, want, have)
+		}
+	}
+	if _, ok := metrics[`govc_esx_boot_timestamp_seconds{cluster="NONE",dc="DC0",name="DC0_H0",vc="vcsim"}`]; !ok {
+		t.Errorf("missing the boot time of DC0_H0")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type eventsCollector struct {
+	vcCollector
+	events typedDesc
+}
+
+const (
+	eventsCollectorSubsystem = "events"
+	// eventsPageSize is the maximum number of events read per request.
+	eventsPageSize = 1000
+)
+
+func init() {
+	registerCollector(eventsCollectorSubsystem, defaultDisabled, NewEventsCollector)
+}
+
+// NewEventsCollector returns a new Collector counting the vc events by type.
+func NewEventsCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	res := eventsCollector{
+		events: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, eventsCollectorSubsystem, "total"),
+			"vc events since the exporter started",
+			[]string{"vc", "dc", "cluster", "type"}, nil), prometheus.CounterValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, eventsCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *eventsCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	tail := c.session.eventTail()
+	tail.mux.Lock()
+	defer tail.mux.Unlock()
+
+	n, err := tail.read(scrape, c.filter)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable read events", "err", err)
+		return err
+	}
+	level.Debug(c.logger).Log("msg", "events read", "num", n)
+
+	vc := c.session.url
+	for k, v := range tail.counts {
+		ch <- c.events.mustNewConstMetric(v, vc, k.dc, k.cluster, k.kind)
+	}
+	return nil
+}
+
+// read counts the events published since the previous read and returns
+// their number.
+func (t *eventTail) read(scrape *Scrape, filter FilterConfig) (int, error) {
+	eventTypes := literalIncludes(filter)
+	if t.collector == nil || t.client != scrape.client || !reflect.DeepEqual(t.types, eventTypes) {
+		if err := t.create(scrape, eventTypes); err != nil {
+			return 0, err
+		}
+	}
+	n, err := t.readAll(scrape, filter)
+	if err != nil {
+		// the collector does not survive a relogin, recreate it once.
+		if err := t.create(scrape, eventTypes); err != nil {
+			return n, err
+		}
+		m, err := t.readAll(scrape, filter)
+		return n + m, err
+	}
+	return n, nil
+}
+
+func (t *eventTail) readAll(scrape *Scrape, filter FilterConfig) (int, error) {
+	n := 0
+	for {
+		req := types.ReadNextEvents{
+			This:     t.collector.Reference(),
+			MaxCount: eventsPageSize,
+		}
+		res, err := methods.ReadNextEvents(scrape.ctx, scrape.client, &req)
+		if err != nil {
+			return n, err
+		}
+		if len(res.Returnval) == 0 {
+			return n, nil
+		}
+		events := res.Returnval
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].GetEvent().Key < events[j].GetEvent().Key
+		})
+		for _, be := range events {
+			e := be.GetEvent()
+			if e.Key <= t.lastKey {
+				continue
+			}
+			t.lastKey = e.Key
+			t.lastTime = e.CreatedTime
+			kind := eventType(be)
+			if !filter.Match(kind) {
+				continue
+			}
+			key := eventCountKey{dc: "NONE", cluster: "NONE", kind: kind}
+			if e.Datacenter != nil {
+				key.dc = e.Datacenter.Name
+			}
+			if e.ComputeResource != nil {
+				key.cluster = e.ComputeResource.Name
+			}
+			t.counts[key]++
+			n++
+		}
+	}
+}
+
+// create replaces the history collector by one starting at the last event
+// read, or at the current vc time on first use.
+func (t *eventTail) create(scrape *Scrape, eventTypes []string) error {
+	if t.collector != nil && t.client == scrape.client {
+		_ = t.collector.Destroy(scrape.ctx)
+	}
+	t.collector = nil
+
+	begin := t.lastTime
+	if begin.IsZero() {
+		now, err := methods.GetCurrentTime(scrape.ctx, scrape.client)
+		if err != nil {
+			return err
+		}
+		begin = *now
+	}
+	req := types.CreateCollectorForEvents{
+		This: *scrape.client.ServiceContent.EventManager,
+		Filter: types.EventFilterSpec{
+			Time:        &types.EventFilterSpecByTime{BeginTime: &begin},
+			EventTypeId: eventTypes,
+		},
+	}
+	res, err := methods.CreateCollectorForEvents(scrape.ctx, scrape.client, &req)
+	if err != nil {
+		return err
+	}
+	t.client = scrape.client
+	t.collector = object.NewHistoryCollector(scrape.client.Client, res.Returnval)
+	t.types = eventTypes
+	return nil
+}
+
+// eventType returns the type of an event, the type id of the extended
+// events, e.g. com.vmware.vc.HA.VmRestartedByHAEvent, or the name of the
+// event class, e.g. VmMigratedEvent.
+func eventType(be types.BaseEvent) string {
+	switch e := be.(type) {
+	case *types.EventEx:
+		return e.EventTypeId
+	case *types.ExtendedEvent:
+		return e.EventTypeId
+	}
+	return reflect.TypeOf(be).Elem().Name()
+}
+
+// literalIncludes returns the include filters when they are all plain event
+// types, so that the vc only returns those, nil otherwise.
+func literalIncludes(filter FilterConfig) []string {
+	if len(filter.Include) == 0 {
+		return nil
+	}
+	res := make([]string, 0, len(filter.Include))
+	for _, re := range filter.Include {
+		r, err := regexp.Compile(re.original)
+		if err != nil {
+			return nil
+		}
+		prefix, complete := r.LiteralPrefix()
+		if !complete {
+			return nil
+		}
+		res = append(res, prefix)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"gopkg.in/yaml.v2"
+)
+
+func postEvent(t *testing.T, ctx context.Context, vc *vim25.Client, e types.BaseEvent) {
+	t.Helper()
+	_, err := methods.PostEvent(ctx, vc, &types.PostEvent{This: *vc.ServiceContent.EventManager, EventToPost: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func eventsFilter(t *testing.T, s string) *Config {
+	var filters FilterConfig
+	if err := yaml.Unmarshal([]byte(s), &filters); err != nil {
+		t.Fatal(err)
+	}
+	return &Config{Collectors: map[string]CollectorConfig{eventsCollectorSubsystem: {Filters: filters}}}
+}
+
+func TestLiteralIncludes(t *testing.T) {
+	for filter, want := range map[string][]string{
+		"": nil,
+		"include: [VmPoweredOnEvent, VmPoweredOffEvent]":         {"VmPoweredOffEvent", "VmPoweredOnEvent"},
+		`include: ['com\.vmware\.vc\.HA\.VmRestartedByHAEvent']`: {"com.vmware.vc.HA.VmRestartedByHAEvent"},
+		"include: [VmPoweredOnEvent, 'Vm.*Event']":               nil,
+		"include: ['VmPowered(On|Off)Event']":                    nil,
+		"exclude: [VmPoweredOnEvent]":                            nil,
+	} {
+		config := eventsFilter(t, filter)
+		if have := literalIncludes(config.Collectors[eventsCollectorSubsystem].Filters); !reflect.DeepEqual(have, want) {
+			t.Errorf("%q: want %v pushed to vc, have %v", filter, want, have)
+		}
+	}
+}
+
+func TestEventsCursor(t *testing.T) {
+	simulator.Test(func(ctx context.Context, vc *vim25.Client) {
+		scrape := simulatorScrape(t, ctx, vc)
+		session := &Session{url: "vcsim"}
+		user := func() types.BaseEvent {
+			return &types.GeneralUserEvent{GeneralEvent: types.GeneralEvent{Event: types.Event{
+				Datacenter: &types.DatacenterEventArgument{EntityEventArgument: types.EntityEventArgument{Name: "DC0"}},
+			}}}
+		}
+		series := `govc_events_total{cluster="NONE",dc="DC0",type="GeneralUserEvent",vc="vcsim"}`
+		// Each read uses a new collector, as after a config reload.
+		read := func() float64 {
+			c, err := NewEventsCollector(log.NewNopLogger(), session, &Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return collectScrape(t, c, scrape)[series]
+		}
+
+		postEvent(t, ctx, vc, user())
+		if n := read(); n != 0 {
+			t.Errorf("want the events posted before the first read skipped, have %v", n)
+		}
+		postEvent(t, ctx, vc, user())
+		postEvent(t, ctx, vc, user())
+		if n := read(); n != 2 {
+			t.Errorf("want 2 events, have %v", n)
+		}
+		if n := read(); n != 2 {
+			t.Errorf("want the events counted once, have %v", n)
+		}
+
+		// The history collector is lost with the vc session on relogin, it
+		// is recreated from the last event read, which is read again.
+		if err := session.events.collector.Destroy(ctx); err != nil {
+			t.Fatal(err)
+		}
+		postEvent(t, ctx, vc, user())
+		if n := read(); n != 3 {
+			t.Errorf("want 3 events once the collector is recreated, have %v", n)
+		}
+
+		// A new client, e.g. once the credentials changed, gets its own
+		// collector.
+		scrape = &Scrape{ctx: ctx, client: &govmomi.Client{Client: vc}, hierarchy: scrape.hierarchy}
+		postEvent(t, ctx, vc, user())
+		if n := read(); n != 4 {
+			t.Errorf("want 4 events with a new client, have %v", n)
+		}
+	})
+}
+
+func TestEventsFilterPushdown(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		types  []string
+	}{
+		{"include: [VmPoweredOffEvent]", []string{"VmPoweredOffEvent"}},
+		{"include: ['VmPowered(Off|Suspended)Event']", nil},
+	} {
+		simulator.Test(func(ctx context.Context, vc *vim25.Client) {
+			scrape := simulatorScrape(t, ctx, vc)
+			session := &Session{url: "vcsim"}
+			c, err := NewEventsCollector(log.NewNopLogger(), session, eventsFilter(t, test.filter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			collectScrape(t, c, scrape)
+			if !reflect.DeepEqual(session.events.types, test.types) {
+				t.Errorf("%s: want event types %v pushed to vc, have %v", test.filter, test.types, session.events.types)
+			}
+
+			vm := types.VmEvent{Event: types.Event{
+				Datacenter: &types.DatacenterEventArgument{EntityEventArgument: types.EntityEventArgument{Name: "DC0"}},
+				Host:       &types.HostEventArgument{EntityEventArgument: types.EntityEventArgument{Name: "DC0_H0"}},
+				Vm:         &types.VmEventArgument{EntityEventArgument: types.EntityEventArgument{Name: "DC0_H0_VM0"}},
+			}}
+			postEvent(t, ctx, vc, &types.VmPoweredOffEvent{VmEvent: vm})
+			postEvent(t, ctx, vc, &types.VmPoweredOnEvent{VmEvent: vm})
+			metrics := collectScrape(t, c, scrape)
+			want := map[string]float64{`govc_events_total{cluster="NONE",dc="DC0",type="VmPoweredOffEvent",vc="vcsim"}`: 1}
+			if !reflect.DeepEqual(metrics, want) {
+				t.Errorf("%s: want %v, have %v", test.filter, want, metrics)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	perfCollectorSubsystem = "perf"
+	// perfBatchSize is the default number of objects per QueryPerf request.
+	perfBatchSize = 64
+)
+
+// perfKind describes the objects of a kind queried by the perf collector.
+type perfKind struct {
+	kind string
+	// interval is the sampling period queried, in seconds. Datastores have
+	// no realtime statistics.
+	interval int32
+	labels   []string
+	// defaultCounters are queried when the configuration sets none.
+	defaultCounters []string
+}
+
+var perfKinds = map[string]perfKind{
+	"vm": {
+		kind:     "VirtualMachine",
+		interval: 20,
+		labels:   []string{"vc", "dc", "cluster", "esx", "pool", "name"},
+		defaultCounters: []string{
+			"cpu.ready.summation",
+			"cpu.costop.summation",
+			"disk.maxTotalLatency.latest",
+			"net.packetsRx.summation",
+			"net.packetsTx.summation",
+		},
+	},
+	"esx": {
+		kind:     "HostSystem",
+		interval: 20,
+		labels:   []string{"vc", "dc", "cluster", "name"},
+		defaultCounters: []string{
+			"cpu.ready.summation",
+			"disk.maxTotalLatency.latest",
+			"net.received.average",
+			"net.transmitted.average",
+		},
+	},
+	"ds": {
+		kind:     "Datastore",
+		interval: 300,
+		labels:   []string{"vc", "dc", "name"},
+	},
+}
+
+type perfCollector struct {
+	vcCollector
+	// queries are the counters queried by object kind.
+	queries   map[string][]perfQuery
+	batchSize int
+	// unknown warns once about the counters missing from the vc.
+	unknown sync.Once
+}
+
+// perfQuery is a counter queried for an instance and the desc of its
+// series.
+type perfQuery struct {
+	counter  string
+	instance string
+	desc     *prometheus.Desc
+}
+
+// perfOptions are the options of the perf collector in the configuration
+// file.
+type perfOptions struct {
+	// Counters are the counters queried by object kind.
+	Counters map[string][]perfCounter `yaml:"counters"`
+	// Instance is queried for the counters which do not set their own.
+	Instance  string `yaml:"instance"`
+	BatchSize int    `yaml:"batch_size"`
+}
+
+// perfCounter is a counter of the configuration file, given by its name,
+// e.g. cpu.ready.summation, or along with its own instance, e.g.
+// {counter: net.received.average, instance: "*"}.
+type perfCounter struct {
+	Counter  string  `yaml:"counter"`
+	Instance *string `yaml:"instance"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *perfCounter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Counter); err == nil {
+		return nil
+	}
+	type plain perfCounter
+	return unmarshal((*plain)(c))
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (c perfCounter) MarshalYAML() (interface{}, error) {
+	if c.Instance == nil {
+		return c.Counter, nil
+	}
+	type plain perfCounter
+	return plain(c), nil
+}
+
+func init() {
+	registerCollector(perfCollectorSubsystem, defaultDisabled, NewPerfCollector)
+	registerOptions(perfCollectorSubsystem, func() collectorOptions { return &perfOptions{} })
+}
+
+func (o *perfOptions) validate() error {
+	for kind, counters := range o.Counters {
+		if _, ok := perfKinds[kind]; !ok {
+			return fmt.Errorf("unsupported counters kind %q", kind)
+		}
+		seen := make(map[string]bool, len(counters))
+		for _, counter := range counters {
+			if strings.Count(counter.Counter, ".") != 2 {
+				return fmt.Errorf("counter %q is not of the form group.name.rollup", counter.Counter)
+			}
+			if seen[counter.Counter] {
+				return fmt.Errorf("counter %q repeated for %s", counter.Counter, kind)
+			}
+			seen[counter.Counter] = true
+		}
+	}
+	if o.BatchSize < 0 {
+		return fmt.Errorf("negative batch_size")
+	}
+	return nil
+}
+
+// NewPerfCollector returns a new Collector exposing the performance counters
+// of the vms, hosts and datastores.
+func NewPerfCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	options := config.collectorConfig(perfCollectorSubsystem).Options.(*perfOptions)
+	counters := options.Counters
+	if len(counters) == 0 {
+		counters = make(map[string][]perfCounter)
+		for name, kind := range perfKinds {
+			for _, counter := range kind.defaultCounters {
+				counters[name] = append(counters[name], perfCounter{Counter: counter})
+			}
+		}
+	}
+	res := perfCollector{
+		queries:   make(map[string][]perfQuery, len(counters)),
+		batchSize: options.BatchSize,
+	}
+	for name, list := range counters {
+		kind := perfKinds[name]
+		labels := append(append([]string{}, kind.labels...), "instance")
+		for _, counter := range list {
+			instance := options.Instance
+			if counter.Instance != nil {
+				instance = *counter.Instance
+			}
+			res.queries[name] = append(res.queries[name], perfQuery{
+				counter:  counter.Counter,
+				instance: instance,
+				desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, perfCollectorSubsystem, name+"_"+strings.Replace(counter.Counter, ".", "_", -1)),
+					fmt.Sprintf("%s performance counter %s", name, counter.Counter),
+					labels, nil),
+			})
+		}
+	}
+	if res.batchSize == 0 {
+		res.batchSize = perfBatchSize
+	}
+	res.vcCollector = newVCCollector(logger, session, config, perfCollectorSubsystem)
+	return &res, nil
+}
+
+// perfEntity is an object queried and its label values.
+type perfEntity struct {
+	ref    types.ManagedObjectReference
+	labels []string
+}
+
+func (c *perfCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	ids, err := c.session.perfCounterIDs(scrape)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve performance counters", "err", err)
+		return err
+	}
+
+	c.unknown.Do(func() {
+		for name, queries := range c.queries {
+			for _, q := range queries {
+				if _, ok := ids[q.counter]; !ok {
+					level.Warn(c.logger).Log("msg", "unknown performance counter", "kind", name, "counter", q.counter)
+				}
+			}
+		}
+	})
+
+	for name, queries := range c.queries {
+		kind := perfKinds[name]
+		var metricIDs []types.PerfMetricId
+		descByID := make(map[int32]*prometheus.Desc, len(queries))
+		for _, q := range queries {
+			id, ok := ids[q.counter]
+			if !ok {
+				continue
+			}
+			metricIDs = append(metricIDs, types.PerfMetricId{CounterId: id, Instance: q.instance})
+			descByID[id] = q.desc
+		}
+		if len(metricIDs) == 0 {
+			continue
+		}
+
+		entities, err := c.entities(scrape, name)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "unable retrieve objects", "kind", kind.kind, "err", err)
+			return err
+		}
+		level.Debug(c.logger).Log("msg", "performance objects retrieved", "kind", kind.kind, "num", len(entities))
+
+		for start := 0; start < len(entities); start += c.batchSize {
+			end := start + c.batchSize
+			if end > len(entities) {
+				end = len(entities)
+			}
+			if err := c.query(scrape, kind, entities[start:end], metricIDs, descByID, ch); err != nil {
+				level.Error(c.logger).Log("msg", "unable query performance counters", "kind", kind.kind, "err", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// query sends a single QueryPerf request for the latest sample of the
+// entities.
+func (c *perfCollector) query(scrape *Scrape, kind perfKind, entities []perfEntity, metricIDs []types.PerfMetricId, descs map[int32]*prometheus.Desc, ch chan<- prometheus.Metric) error {
+	labels := make(map[types.ManagedObjectReference][]string, len(entities))
+	specs := make([]types.PerfQuerySpec, 0, len(entities))
+	for _, e := range entities {
+		labels[e.ref] = e.labels
+		specs = append(specs, types.PerfQuerySpec{
+			Entity:     e.ref,
+			MaxSample:  1,
+			MetricId:   metricIDs,
+			IntervalId: kind.interval,
+			Format:     string(types.PerfFormatNormal),
+		})
+	}
+	req := types.QueryPerf{
+		This:      *scrape.client.ServiceContent.PerfManager,
+		QuerySpec: specs,
+	}
+	res, err := methods.QueryPerf(scrape.ctx, scrape.client, &req)
+	if err != nil {
+		return err
+	}
+
+	for _, base := range res.Returnval {
+		metric, ok := base.(*types.PerfEntityMetric)
+		if !ok {
+			continue
+		}
+		values, ok := labels[metric.Entity]
+		if !ok {
+			continue
+		}
+		for _, series := range metric.Value {
+			s, ok := series.(*types.PerfMetricIntSeries)
+			if !ok || len(s.Value) == 0 {
+				continue
+			}
+			desc, ok := descs[s.Id.CounterId]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(s.Value[len(s.Value)-1]), append(values, s.Id.Instance)...)
+		}
+	}
+	return nil
+}
+
+// entities returns the objects of kind with statistics: the powered on vms,
+// the connected hosts and the accessible datastores.
+func (c *perfCollector) entities(scrape *Scrape, name string) ([]perfEntity, error) {
+	vc := c.session.url
+	var res []perfEntity
+	switch name {
+	case "vm":
+		var items []mo.VirtualMachine
+		if err := c.retrieve(scrape, "VirtualMachine", []string{"name", "parent", "resourcePool", "runtime"}, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn || !c.filter.Match(item.Name) {
+				continue
+			}
+			var parents Parents
+			if item.ResourcePool == nil {
+				parents = scrape.hierarchy.Parents(item.Parent)
+			} else {
+				parents = scrape.hierarchy.Parents(item.ResourcePool)
+			}
+			res = append(res, perfEntity{item.Self, []string{
+				vc, parents.dc, parents.cluster,
+				scrape.hierarchy.Name(item.Runtime.Host), scrape.hierarchy.Name(item.ResourcePool),
+				item.Name,
+			}})
+		}
+	case "esx":
+		var items []mo.HostSystem
+		if err := c.retrieve(scrape, "HostSystem", []string{"name", "parent", "runtime"}, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Runtime.ConnectionState != types.HostSystemConnectionStateConnected || !c.filter.Match(item.Name) {
+				continue
+			}
+			parents := scrape.hierarchy.Parents(item.Parent)
+			res = append(res, perfEntity{item.Self, []string{vc, parents.dc, parents.cluster, item.Name}})
+		}
+	case "ds":
+		var items []mo.Datastore
+		if err := c.retrieve(scrape, "Datastore", []string{"name", "parent", "summary"}, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if !item.Summary.Accessible || !c.filter.Match(item.Name) {
+				continue
+			}
+			parents := scrape.hierarchy.Parents(item.Parent)
+			res = append(res, perfEntity{item.Self, []string{vc, parents.dc, item.Name}})
+		}
+	}
+	return res, nil
+}
+
+// perfCounterIDs returns the ids of the performance counters of the vc by
+// name, e.g. cpu.ready.summation, retrieved once per session.
+func (s *Session) perfCounterIDs(scrape *Scrape) (map[string]int32, error) {
+	s.mux.Lock()
+	ids := s.perfCounters
+	s.mux.Unlock()
+	if ids != nil {
+		return ids, nil
+	}
+
+	var pm mo.PerformanceManager
+	pc := property.DefaultCollector(scrape.client.Client)
+	err := pc.RetrieveOne(scrape.ctx, *scrape.client.ServiceContent.PerfManager, []string{"perfCounter"}, &pm)
+	if err != nil {
+		return nil, err
+	}
+	ids = make(map[string]int32, len(pm.PerfCounter))
+	for _, info := range pm.PerfCounter {
+		name := fmt.Sprintf("%s.%s.%s",
+			info.GroupInfo.GetElementDescription().Key,
+			info.NameInfo.GetElementDescription().Key,
+			info.RollupType)
+		ids[name] = info.Key
+	}
+
+	s.mux.Lock()
+	s.perfCounters = ids
+	s.mux.Unlock()
+	return ids, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"gopkg.in/yaml.v2"
+)
+
+// queryPerfCounter counts the QueryPerf requests sent through it.
+type queryPerfCounter struct {
+	soap.RoundTripper
+	queries int
+}
+
+func (c *queryPerfCounter) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if _, ok := req.(*methods.QueryPerfBody); ok {
+		c.queries++
+	}
+	return c.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+func TestPerfOptions(t *testing.T) {
+	var options perfOptions
+	err := yaml.UnmarshalStrict([]byte(`
+counters:
+  esx:
+    - cpu.ready.summation
+    - {counter: net.received.average, instance: "*"}
+instance: ""
+`), &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esx := options.Counters["esx"]
+	if len(esx) != 2 || esx[0].Counter != "cpu.ready.summation" || esx[0].Instance != nil ||
+		esx[1].Counter != "net.received.average" || esx[1].Instance == nil || *esx[1].Instance != "*" {
+		t.Errorf("unexpected esx counters %+v", esx)
+	}
+
+	err = yaml.UnmarshalStrict([]byte(`counters: {esx: [{counter: net.received.average, instances: "*"}]}`), &options)
+	if err == nil || !strings.Contains(err.Error(), "field instances not found") {
+		t.Errorf("want the unknown counter field rejected, have %v", err)
+	}
+}
+
+func TestPerfCollector(t *testing.T) {
+	star := "*"
+	config := &Config{Collectors: map[string]CollectorConfig{perfCollectorSubsystem: {Options: &perfOptions{
+		Counters: map[string][]perfCounter{
+			"vm": {
+				{Counter: "cpu.ready.summation"},
+				{Counter: "net.packetsRx.summation", Instance: &star},
+				{Counter: "missing.counter.latest"},
+			},
+		},
+		BatchSize: 1,
+	}}}}
+	c, err := NewPerfCollector(log.NewNopLogger(), &Session{url: "vcsim"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &queryPerfCounter{}
+	metrics := collectSimulator(t, c, func(ctx context.Context, vc *vim25.Client) {
+		rt.RoundTripper = vc.RoundTripper
+		vc.RoundTripper = rt
+	})
+
+	vms := 0
+	for series := range metrics {
+		switch {
+		case strings.HasPrefix(series, "govc_perf_vm_cpu_ready_summation{"):
+			vms++
+			if !strings.Contains(series, `instance=""`) {
+				t.Errorf("want the aggregate instance, have %s", series)
+			}
+		case strings.HasPrefix(series, "govc_perf_vm_net_packetsRx_summation{"):
+			if !strings.Contains(series, `instance="*"`) {
+				t.Errorf("want the instance of the counter, have %s", series)
+			}
+		default:
+			t.Errorf("unexpected series %s", series)
+		}
+	}
+	if vms == 0 || len(metrics) != 2*vms {
+		t.Errorf("want both counters for every vm, have %v", metrics)
+	}
+	if rt.queries != vms {
+		t.Errorf("want a QueryPerf request per vm with batch_size 1, have %d for %d vms", rt.queries, vms)
+	}
+	if _, ok := metrics[`govc_perf_vm_cpu_ready_summation{cluster="NONE",dc="DC0",esx="DC0_H0",instance="",name="DC0_H0_VM0",pool="Resources",vc="vcsim"}`]; !ok {
+		t.Errorf("missing the cpu ready of DC0_H0_VM0 in %v", metrics)
+	}
+}
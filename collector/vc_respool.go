@@ -19,7 +19,6 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 )
 
@@ -51,7 +50,7 @@ func init() {
 }
 
 // NewResourcePoolCollector returns a new Collector exposing IpTables stats.
-func NewResourcePoolCollector(logger log.Logger) (Collector, error) {
+func NewResourcePoolCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
 	labels := []string{"vc", "dc", "name"}
 
 	res := resourcePoolCollector{
@@ -101,27 +100,19 @@ func NewResourcePoolCollector(logger log.Logger) (Collector, error) {
 			prometheus.BuildFQName(namespace, resourcePoolCollectorSubsystem, "mem_limit_bytes"),
 			"ressource pool memory limit in bytes", labels, nil), prometheus.GaugeValue},
 	}
-	res.logger = logger
+	res.vcCollector = newVCCollector(logger, session, config, resourcePoolCollectorSubsystem)
 	return &res, nil
 }
 
-func (c *resourcePoolCollector) Update(ch chan<- prometheus.Metric) (err error) {
+func (c *resourcePoolCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
 
-	cache.Flush()
-
-	err = c.apiConnect()
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to connect", "err", err)
-		return err
-	}
-	defer c.apiDisconnect()
-	items, err := c.apiRetrieve()
+	items, err := c.apiRetrieve(scrape)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "unable retrieve esx", "err", err)
 		return err
 	}
 
-	vc := *vcURL
+	vc := c.session.url
 
 	level.Debug(c.logger).Log("msg", "ressource pool retrieved", "num", len(items))
 
@@ -131,7 +122,10 @@ func (c *resourcePoolCollector) Update(ch chan<- prometheus.Metric) (err error)
 			continue
 		}
 		name := item.Summary.GetResourcePoolSummary().Name
-		tmp := getParents(c.ctx, c.logger, c.client.Client, item.ManagedEntity)
+		if !c.filter.Match(name) {
+			continue
+		}
+		tmp := scrape.hierarchy.Parents(item.Parent)
 
 		labels := []string{vc, tmp.dc, name}
 		mb := int64(1024 * 1024)
@@ -158,24 +152,12 @@ func (c *resourcePoolCollector) Update(ch chan<- prometheus.Metric) (err error)
 	return nil
 }
 
-func (c *resourcePoolCollector) apiRetrieve() ([]mo.ResourcePool, error) {
+func (c *resourcePoolCollector) apiRetrieve(scrape *Scrape) ([]mo.ResourcePool, error) {
 	var items []mo.ResourcePool
 
-	m := view.NewManager(c.client.Client)
-	v, err := m.CreateContainerView(
-		c.ctx,
-		c.client.ServiceContent.RootFolder,
-		[]string{"ResourcePool"},
-		true,
-	)
-	if err != nil {
-		return items, err
-	}
-	defer c.destroyView(v)
-
-	err = v.Retrieve(
-		c.ctx,
-		[]string{"ResourcePool"},
+	err := c.retrieve(
+		scrape,
+		"ResourcePool",
 		[]string{
 			"parent",
 			"summary",
@@ -19,7 +19,6 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 )
 
@@ -38,7 +37,7 @@ func init() {
 }
 
 // NewStoragePodCollector returns a new Collector exposing IpTables stats.
-func NewStoragePodCollector(logger log.Logger) (Collector, error) {
+func NewStoragePodCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
 	labels := []string{"vc", "dc", "name"}
 
 	res := storagePodCollector{
@@ -49,34 +48,29 @@ func NewStoragePodCollector(logger log.Logger) (Collector, error) {
 			prometheus.BuildFQName(namespace, storagePodCollectorSubsystem, "free_space_bytes"),
 			"storagePod freespace in bytes", labels, nil), prometheus.GaugeValue},
 	}
-	res.logger = logger
+	res.vcCollector = newVCCollector(logger, session, config, storagePodCollectorSubsystem)
 	return &res, nil
 }
 
-func (c *storagePodCollector) Update(ch chan<- prometheus.Metric) (err error) {
+func (c *storagePodCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
 
-	cache.Flush()
-
-	err = c.apiConnect()
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to connect", "err", err)
-		return err
-	}
-	defer c.apiDisconnect()
-	items, err := c.apiRetrieve()
+	items, err := c.apiRetrieve(scrape)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "unable retrieve esx", "err", err)
 		return err
 	}
 
-	vc := *vcURL
+	vc := c.session.url
 
 	level.Debug(c.logger).Log("msg", "storagePod retrieved", "num", len(items))
 
 	for _, item := range items {
 		summary := item.Summary
 		name := summary.Name
-		tmp := getParents(c.ctx, c.logger, c.client.Client, item.ManagedEntity)
+		if !c.filter.Match(name) {
+			continue
+		}
+		tmp := scrape.hierarchy.Parents(item.Parent)
 
 		labels := []string{vc, tmp.dc, name}
 		ch <- c.capacity.mustNewConstMetric(float64(summary.Capacity), labels...)
@@ -86,24 +80,12 @@ func (c *storagePodCollector) Update(ch chan<- prometheus.Metric) (err error) {
 	return nil
 }
 
-func (c *storagePodCollector) apiRetrieve() ([]mo.StoragePod, error) {
+func (c *storagePodCollector) apiRetrieve(scrape *Scrape) ([]mo.StoragePod, error) {
 	var items []mo.StoragePod
 
-	m := view.NewManager(c.client.Client)
-	v, err := m.CreateContainerView(
-		c.ctx,
-		c.client.ServiceContent.RootFolder,
-		[]string{"StoragePod"},
-		true,
-	)
-	if err != nil {
-		return items, err
-	}
-	defer c.destroyView(v)
-
-	err = v.Retrieve(
-		c.ctx,
-		[]string{"StoragePod"},
+	err := c.retrieve(
+		scrape,
+		"StoragePod",
 		[]string{
 			"parent",
 			"summary",
@@ -0,0 +1,197 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// taskDurationBuckets are the upper bounds of the task duration histogram,
+// from snapshots to long clones and migrations.
+var taskDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+type tasksCollector struct {
+	vcCollector
+	completed typedDesc
+	duration  *prometheus.Desc
+	active    typedDesc
+}
+
+const (
+	tasksCollectorSubsystem = "tasks"
+)
+
+func init() {
+	registerCollector(tasksCollectorSubsystem, defaultDisabled, NewTasksCollector)
+}
+
+// NewTasksCollector returns a new Collector exposing the completed tasks
+// and their duration, and the active tasks.
+func NewTasksCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	res := tasksCollector{
+		completed: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tasksCollectorSubsystem, "completed_total"),
+			"vc tasks completed since the exporter started",
+			[]string{"vc", "task", "state"}, nil), prometheus.CounterValue},
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tasksCollectorSubsystem, "duration_seconds"),
+			"vc duration of the completed tasks, from their start",
+			[]string{"vc", "task"}, nil),
+		active: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tasksCollectorSubsystem, "active"),
+			"vc number of running and queued tasks",
+			[]string{"vc", "entity_type", "state"}, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, tasksCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *tasksCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	infos, err := c.apiRetrieve(scrape)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve tasks", "err", err)
+		return err
+	}
+	level.Debug(c.logger).Log("msg", "recent tasks retrieved", "num", len(infos))
+
+	tracker := c.session.taskTracker()
+	tracker.mux.Lock()
+	defer tracker.mux.Unlock()
+	tracker.update(infos, c.filter)
+
+	vc := c.session.url
+	for k, v := range tracker.completed {
+		ch <- c.completed.mustNewConstMetric(v, vc, k.task, k.state)
+	}
+	for task, h := range tracker.durations {
+		ch <- prometheus.MustNewConstHistogram(c.duration, h.count, h.sum, h.buckets, vc, task)
+	}
+
+	active := make(map[[2]string]float64)
+	for _, info := range infos {
+		if info.State != types.TaskInfoStateRunning && info.State != types.TaskInfoStateQueued {
+			continue
+		}
+		if !c.filter.Match(info.DescriptionId) {
+			continue
+		}
+		entityType := "NONE"
+		if info.Entity != nil {
+			entityType = info.Entity.Type
+		}
+		active[[2]string{entityType, string(info.State)}]++
+	}
+	for k, v := range active {
+		ch <- c.active.mustNewConstMetric(v, vc, k[0], k[1])
+	}
+	return nil
+}
+
+// apiRetrieve returns the information of the recent tasks of the
+// TaskManager, through a task view which ignores the tasks already gone.
+func (c *tasksCollector) apiRetrieve(scrape *Scrape) ([]types.TaskInfo, error) {
+	var tm mo.TaskManager
+	pc := property.DefaultCollector(scrape.client.Client)
+	err := pc.RetrieveOne(scrape.ctx, *scrape.client.ServiceContent.TaskManager, []string{"recentTask"}, &tm)
+	if err != nil || len(tm.RecentTask) == 0 {
+		return nil, err
+	}
+
+	m := view.NewManager(scrape.client.Client)
+	v, err := m.CreateTaskView(scrape.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = v.Destroy(scrape.ctx)
+	}()
+	if err := v.Reset(scrape.ctx, tm.RecentTask); err != nil {
+		return nil, err
+	}
+
+	req := types.RetrieveProperties{
+		This: pc.Reference(),
+		SpecSet: []types.PropertyFilterSpec{{
+			ObjectSet: []types.ObjectSpec{{
+				Obj:       v.Reference(),
+				Skip:      types.NewBool(true),
+				SelectSet: []types.BaseSelectionSpec{v.TraversalSpec()},
+			}},
+			PropSet: []types.PropertySpec{{Type: "Task", PathSet: []string{"info"}}},
+		}},
+	}
+	res, err := pc.RetrieveProperties(scrape.ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []mo.Task
+	if err := mo.LoadObjectContent(res.Returnval, &tasks); err != nil {
+		return nil, err
+	}
+	infos := make([]types.TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		infos = append(infos, task.Info)
+	}
+	return infos, nil
+}
+
+// update counts the tasks completed since the previous update. The tasks
+// already completed on the first update are only marked as seen.
+func (t *taskTracker) update(infos []types.TaskInfo, filter FilterConfig) {
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if info.CompleteTime == nil {
+			continue
+		}
+		seen[info.Key] = true
+		if !t.started || t.seen[info.Key] || !filter.Match(info.DescriptionId) {
+			continue
+		}
+		t.completed[taskCountKey{info.DescriptionId, string(info.State)}]++
+
+		start := info.QueueTime
+		if info.StartTime != nil {
+			start = *info.StartTime
+		}
+		t.observe(info.DescriptionId, info.CompleteTime.Sub(start).Seconds())
+	}
+	t.seen = seen
+	t.started = true
+}
+
+func (t *taskTracker) observe(task string, seconds float64) {
+	h, ok := t.durations[task]
+	if !ok {
+		h = &taskHistogram{buckets: make(map[float64]uint64, len(taskDurationBuckets))}
+		for _, b := range taskDurationBuckets {
+			h.buckets[b] = 0
+		}
+		t.durations[task] = h
+	}
+	h.count++
+	h.sum += seconds
+	for _, b := range taskDurationBuckets {
+		if seconds <= b {
+			h.buckets[b]++
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestTaskTrackerCountsCompletedOnce(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := func(key string, state types.TaskInfoState, seconds int) types.TaskInfo {
+		info := types.TaskInfo{Key: key, DescriptionId: "VirtualMachine.clone", State: state, QueueTime: start}
+		if seconds > 0 {
+			end := start.Add(time.Duration(seconds) * time.Second)
+			info.StartTime = &start
+			info.CompleteTime = &end
+		}
+		return info
+	}
+
+	tracker := (&Session{}).taskTracker()
+	tracker.update([]types.TaskInfo{task("old", types.TaskInfoStateSuccess, 10)}, FilterConfig{})
+	if len(tracker.completed) != 0 {
+		t.Fatalf("tasks completed before the first update counted: %v", tracker.completed)
+	}
+
+	tracker.update([]types.TaskInfo{
+		task("old", types.TaskInfoStateSuccess, 10),
+		task("t1", types.TaskInfoStateSuccess, 20),
+		task("t2", types.TaskInfoStateError, 400),
+		task("t3", types.TaskInfoStateRunning, 0),
+	}, FilterConfig{})
+	tracker.update([]types.TaskInfo{
+		task("t1", types.TaskInfoStateSuccess, 20),
+		task("t2", types.TaskInfoStateError, 400),
+	}, FilterConfig{})
+
+	success := tracker.completed[taskCountKey{"VirtualMachine.clone", "success"}]
+	failed := tracker.completed[taskCountKey{"VirtualMachine.clone", "error"}]
+	if success != 1 || failed != 1 {
+		t.Errorf("want 1 success and 1 error, have %v and %v", success, failed)
+	}
+	h := tracker.durations["VirtualMachine.clone"]
+	if h.count != 2 || h.sum != 420 || h.buckets[30] != 1 || h.buckets[600] != 2 {
+		t.Errorf("unexpected duration histogram %+v", h)
+	}
+}
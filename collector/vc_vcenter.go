@@ -0,0 +1,169 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type vcenterCollector struct {
+	vcCollector
+	info       typedDesc
+	vms        typedDesc
+	hosts      typedDesc
+	datastores typedDesc
+	networks   typedDesc
+	sessions   typedDesc
+	// sessionsHidden warns once that the session list is not visible.
+	sessionsHidden sync.Once
+}
+
+const (
+	vcenterCollectorSubsystem = "vcenter"
+)
+
+func init() {
+	registerCollector(vcenterCollectorSubsystem, defaultDisabled, NewVcenterCollector)
+}
+
+// NewVcenterCollector returns a new Collector exposing the vc version, the
+// number of objects per datacenter and the number of sessions.
+func NewVcenterCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
+	labels := []string{"vc", "dc"}
+
+	res := vcenterCollector{
+		info: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "info"),
+			"vc product information, always 1",
+			[]string{"vc", "name", "version", "build", "api_version", "os_type", "instance_uuid"}, nil), prometheus.GaugeValue},
+		vms: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "vms"),
+			"vc number of vms by datacenter", labels, nil), prometheus.GaugeValue},
+		hosts: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "hosts"),
+			"vc number of esx hosts by datacenter", labels, nil), prometheus.GaugeValue},
+		datastores: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "datastores"),
+			"vc number of datastores by datacenter", labels, nil), prometheus.GaugeValue},
+		networks: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "networks"),
+			"vc number of networks by datacenter", labels, nil), prometheus.GaugeValue},
+		sessions: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, vcenterCollectorSubsystem, "sessions"),
+			"vc number of active sessions", []string{"vc"}, nil), prometheus.GaugeValue},
+	}
+	res.vcCollector = newVCCollector(logger, session, config, vcenterCollectorSubsystem)
+	return &res, nil
+}
+
+func (c *vcenterCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
+	vc := c.session.url
+
+	about := scrape.client.ServiceContent.About
+	ch <- c.info.mustNewConstMetric(1, vc, about.Name, about.Version, about.Build, about.ApiVersion, about.OsType, about.InstanceUuid)
+
+	counts := []struct {
+		kind string
+		desc typedDesc
+	}{
+		{"VirtualMachine", c.vms},
+		{"HostSystem", c.hosts},
+		{"Datastore", c.datastores},
+		{"Network", c.networks},
+	}
+	for _, count := range counts {
+		byDC, err := c.countByDatacenter(scrape, count.kind)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "unable retrieve objects", "kind", count.kind, "err", err)
+			return err
+		}
+		for dc, n := range byDC {
+			ch <- count.desc.mustNewConstMetric(float64(n), vc, dc)
+		}
+	}
+
+	var sm mo.SessionManager
+	pc := property.DefaultCollector(scrape.client.Client)
+	err = pc.RetrieveOne(scrape.ctx, *scrape.client.ServiceContent.SessionManager, []string{"sessionList", "currentSession"}, &sm)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "unable retrieve sessions", "err", err)
+		return err
+	}
+	if !sessionsVisible(sm) {
+		c.sessionsHidden.Do(func() {
+			level.Warn(c.logger).Log("msg", "session list does not hold the exporter session, the user lacks the Sessions.TerminateSession privilege, skipping the session count")
+		})
+		return nil
+	}
+	ch <- c.sessions.mustNewConstMetric(float64(len(sm.SessionList)), vc)
+	return nil
+}
+
+// sessionsVisible reports whether the session list is complete: without
+// the privilege to list the sessions, vc returns an empty list, missing
+// even the current session.
+func sessionsVisible(sm mo.SessionManager) bool {
+	if sm.CurrentSession == nil {
+		return false
+	}
+	for _, s := range sm.SessionList {
+		if s.Key == sm.CurrentSession.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// countByDatacenter returns the number of objects of kind in each
+// datacenter.
+func (c *vcenterCollector) countByDatacenter(scrape *Scrape, kind string) (map[string]int, error) {
+	var parents []*types.ManagedObjectReference
+	if kind == "VirtualMachine" {
+		// vms in a vApp have no parent folder.
+		var items []mo.VirtualMachine
+		if err := c.retrieve(scrape, kind, []string{"parent", "resourcePool"}, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.ResourcePool != nil {
+				parents = append(parents, item.ResourcePool)
+			} else {
+				parents = append(parents, item.Parent)
+			}
+		}
+	} else {
+		var items []mo.ManagedEntity
+		if err := c.retrieve(scrape, kind, []string{"parent"}, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			parents = append(parents, item.Parent)
+		}
+	}
+
+	res := make(map[string]int)
+	for _, parent := range parents {
+		res[scrape.hierarchy.Parents(parent).dc]++
+	}
+	return res, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestSessionsVisible(t *testing.T) {
+	current := &types.UserSession{Key: "exporter"}
+	for _, test := range []struct {
+		sm      mo.SessionManager
+		visible bool
+	}{
+		{mo.SessionManager{CurrentSession: current, SessionList: []types.UserSession{{Key: "other"}, {Key: "exporter"}}}, true},
+		{mo.SessionManager{CurrentSession: current}, false},
+		{mo.SessionManager{CurrentSession: current, SessionList: []types.UserSession{{Key: "other"}}}, false},
+		{mo.SessionManager{SessionList: []types.UserSession{{Key: "other"}}}, false},
+	} {
+		if visible := sessionsVisible(test.sm); visible != test.visible {
+			t.Errorf("%+v: want visible %v, have %v", test.sm, test.visible, visible)
+		}
+	}
+}
+
+func TestVcenterCollector(t *testing.T) {
+	c, err := NewVcenterCollector(log.NewNopLogger(), &Session{url: "vcsim"}, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := collectSimulator(t, c)
+	for series, value := range map[string]float64{
+		`govc_vcenter_hosts{dc="DC0",vc="vcsim"}`: 4,
+		`govc_vcenter_vms{dc="DC0",vc="vcsim"}`:   4,
+	} {
+		if have, ok := metrics[series]; !ok || have != value {
+			t.Errorf("%s: want %v, have %v", series, value, have)
+		}
+	}
+	if sessions, ok := metrics[`govc_vcenter_sessions{vc="vcsim"}`]; !ok || sessions < 1 {
+		t.Errorf("want the session of the test counted, have %v", sessions)
+	}
+}
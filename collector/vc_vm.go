@@ -17,13 +17,15 @@ package collector
 
 import (
 	"encoding/json"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vmware/govmomi/object"
-	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
@@ -52,9 +54,24 @@ type virtualMachineCollector struct {
 	uptimeSeconds                typedDesc
 	ssdSwappedMemory             typedDesc
 	numSnapshot                  typedDesc
+	snapshotCreateTime           typedDesc
+	snapshotDeltaBytes           typedDesc
 	diskCapacityBytes            typedDesc
+	diskCommittedBytes           typedDesc
 	networkConnected             typedDesc
 	ethernetDriverConnected      typedDesc
+	committedBytes               typedDesc
+	uncommittedBytes             typedDesc
+	unsharedBytes                typedDesc
+	datastoreCommittedBytes      typedDesc
+	datastoreUncommittedBytes    typedDesc
+	datastoreUnsharedBytes       typedDesc
+	guestDiskCapacityBytes       typedDesc
+	guestDiskFreeBytes           typedDesc
+	// mounts selects the guest filesystems by mount point.
+	mounts FilterConfig
+	// intrinsec adds the labels parsed from the vm annotation.
+	intrinsec bool
 }
 
 const (
@@ -63,10 +80,32 @@ const (
 
 func init() {
 	registerCollector(virtualMachineCollectorSubsystem, defaultEnabled, NewVirtualMachineCollector)
+	registerProperties(virtualMachineCollectorSubsystem, []string{
+		"config",
+		"guest",
+		"guestHeartbeatStatus",
+		"layoutEx",
+		"network",
+		"resourceConfig",
+		"snapshot",
+		"storage",
+	})
+	registerOptions(virtualMachineCollectorSubsystem, func() collectorOptions { return &virtualMachineOptions{} })
+}
+
+// virtualMachineOptions are the options of the vm collector in the
+// configuration file.
+type virtualMachineOptions struct {
+	// Mounts selects the guest filesystems by mount point.
+	Mounts FilterConfig `yaml:"mounts"`
+}
+
+func (o *virtualMachineOptions) validate() error {
+	return nil
 }
 
 // NewVirtualMachineCollector returns a new Collector exposing IpTables stats.
-func NewVirtualMachineCollector(logger log.Logger) (Collector, error) {
+func NewVirtualMachineCollector(logger log.Logger, session *Session, config *Config) (Collector, error) {
 
 	labels := []string{
 		"vc", "dc", "cluster", "esx", "pool",
@@ -74,20 +113,30 @@ func NewVirtualMachineCollector(logger log.Logger) (Collector, error) {
 		"power_state", "overall_status",
 		"tools_status", "tools_version",
 	}
-	if *useIsecSpecifics {
+	intrinsec := *useIsecSpecifics || config.Labels.Intrinsec
+	if intrinsec {
 		labels = append(labels, "crit", "responsable", "service")
 	}
 	networkLabels := make([]string, len(labels))
 	ethernetDevLabels := make([]string, len(labels))
 	diskLabels := make([]string, len(labels))
+	snapshotLabels := make([]string, len(labels))
+	guestDiskLabels := make([]string, len(labels))
+	datastoreLabels := make([]string, len(labels))
 
 	copy(networkLabels, labels)
 	copy(ethernetDevLabels, labels)
 	copy(diskLabels, labels)
+	copy(snapshotLabels, labels)
+	copy(guestDiskLabels, labels)
+	copy(datastoreLabels, labels)
 
 	networkLabels = append(networkLabels, "network", "mac", "ip")
 	ethernetDevLabels = append(ethernetDevLabels, "driver_model", "driver_mac", "driver_status")
-	diskLabels = append(diskLabels, "vmdk")
+	diskLabels = append(diskLabels, "vmdk", "backing", "thin", "disk_mode", "controller_key", "unit_number", "datastore", "sharing")
+	snapshotLabels = append(snapshotLabels, "snapshot", "id", "memory", "quiesced", "depth")
+	guestDiskLabels = append(guestDiskLabels, "path", "filesystem")
+	datastoreLabels = append(datastoreLabels, "datastore")
 
 	res := virtualMachineCollector{
 
@@ -177,11 +226,23 @@ func NewVirtualMachineCollector(logger log.Logger) (Collector, error) {
 
 		numSnapshot: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "snapshot_number_total"),
-			"vm number of snapshot", labels, nil), prometheus.GaugeValue},
+			"vm number of snapshots, nested ones included", labels, nil), prometheus.GaugeValue},
+
+		snapshotCreateTime: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "snapshot_create_timestamp_seconds"),
+			"vm snapshot creation time since unix epoch in seconds", snapshotLabels, nil), prometheus.GaugeValue},
+
+		snapshotDeltaBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "snapshot_delta_bytes"),
+			"vm bytes used by the snapshot delta disks", labels, nil), prometheus.GaugeValue},
 
 		diskCapacityBytes: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "disk_capacity_bytes"),
-			"vm disk capacity bytes", diskLabels, nil), prometheus.GaugeValue},
+			"vm disk provisioned capacity in bytes", diskLabels, nil), prometheus.GaugeValue},
+
+		diskCommittedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "disk_committed_bytes"),
+			"vm disk bytes committed on the datastore, snapshot deltas included", diskLabels, nil), prometheus.GaugeValue},
 
 		networkConnected: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "network_connected"),
@@ -190,50 +251,74 @@ func NewVirtualMachineCollector(logger log.Logger) (Collector, error) {
 		ethernetDriverConnected: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "ethernet_driver_connected"),
 			"vm ethernet driver connected", ethernetDevLabels, nil), prometheus.GaugeValue},
+
+		committedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "committed_bytes"),
+			"vm storage committed on the datastores in bytes", labels, nil), prometheus.GaugeValue},
+
+		uncommittedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "uncommitted_bytes"),
+			"vm storage that may be committed on the datastores in bytes", labels, nil), prometheus.GaugeValue},
+
+		unsharedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "unshared_bytes"),
+			"vm storage not shared with other vms in bytes", labels, nil), prometheus.GaugeValue},
+
+		datastoreCommittedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "datastore_committed_bytes"),
+			"vm storage committed on a datastore in bytes", datastoreLabels, nil), prometheus.GaugeValue},
+
+		datastoreUncommittedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "datastore_uncommitted_bytes"),
+			"vm storage that may be committed on a datastore in bytes", datastoreLabels, nil), prometheus.GaugeValue},
+
+		datastoreUnsharedBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "datastore_unshared_bytes"),
+			"vm storage not shared with other vms on a datastore in bytes", datastoreLabels, nil), prometheus.GaugeValue},
+
+		guestDiskCapacityBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "guest_disk_capacity_bytes"),
+			"vm guest filesystem capacity in bytes", guestDiskLabels, nil), prometheus.GaugeValue},
+
+		guestDiskFreeBytes: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, virtualMachineCollectorSubsystem, "guest_disk_free_bytes"),
+			"vm guest filesystem free space in bytes", guestDiskLabels, nil), prometheus.GaugeValue},
 	}
-	res.logger = logger
+	res.vcCollector = newVCCollector(logger, session, config, virtualMachineCollectorSubsystem)
+	res.mounts = config.collectorConfig(virtualMachineCollectorSubsystem).Options.(*virtualMachineOptions).Mounts
+	res.intrinsec = intrinsec
 	return &res, nil
 }
 
-func (c *virtualMachineCollector) Update(ch chan<- prometheus.Metric) (err error) {
-
-	cache.Flush()
+func (c *virtualMachineCollector) Update(scrape *Scrape, ch chan<- prometheus.Metric) (err error) {
 
-	err = c.apiConnect()
-	if err != nil {
-		level.Error(c.logger).Log("msg", "unable to connect", "err", err)
-		return err
-	}
-	defer c.apiDisconnect()
-	items, err := c.apiRetrieve()
+	items, err := c.apiRetrieve(scrape)
 	if err != nil {
 		level.Error(c.logger).Log("msg", "unable retrieve vm", "err", err)
 		return err
 	}
 
-	vc := *vcURL
+	vc := c.session.url
 
 	level.Debug(c.logger).Log("msg", "virtual machine retrieved", "num", len(items))
 
 	for _, item := range items {
+		if !c.filter.Match(item.Summary.Config.Name) {
+			continue
+		}
 
-		var esxName string
-		var poolName string
 		var parents Parents
-
-		pool := getVMPool(c.ctx, c.logger, c.client.Client, item)
-		if pool == nil {
-			parents = getParents(c.ctx, c.logger, c.client.Client, item.ManagedEntity)
-			poolName = "NONE"
+		if item.ResourcePool == nil {
+			parents = scrape.hierarchy.Parents(item.Parent)
 		} else {
-			parents = getParents(c.ctx, c.logger, c.client.Client, *pool)
-			poolName = pool.Name
+			parents = scrape.hierarchy.Parents(item.ResourcePool)
 		}
-		host := getVMHostSystem(c.ctx, c.logger, c.client.Client, item)
-		if host == nil {
-			esxName = "NONE"
-		} else {
-			esxName = host.Name
+		poolName := scrape.hierarchy.Name(item.ResourcePool)
+		esxName := scrape.hierarchy.Name(item.Summary.Runtime.Host)
+		toolsStatus, toolsVersion := "NONE", "NONE"
+		if item.Guest != nil {
+			toolsStatus = string(item.Guest.ToolsStatus)
+			toolsVersion = item.Guest.ToolsVersion
 		}
 
 		labelsValues := []string{
@@ -247,11 +332,11 @@ func (c *virtualMachineCollector) Update(ch chan<- prometheus.Metric) (err error
 			item.Summary.Guest.GuestFullName,
 			string(item.Runtime.PowerState),
 			string(item.Summary.OverallStatus),
-			string(item.Guest.ToolsStatus),
-			item.Guest.ToolsVersion,
+			toolsStatus,
+			toolsVersion,
 		}
 
-		if *useIsecSpecifics {
+		if c.intrinsec {
 			annotation := GetIsecAnnotation(item)
 			labelsValues = append(
 				labelsValues,
@@ -262,9 +347,11 @@ func (c *virtualMachineCollector) Update(ch chan<- prometheus.Metric) (err error
 		}
 		mb := int64(1024 * 1024)
 
-		ch <- c.numCPU.mustNewConstMetric(float64(item.Config.Hardware.NumCPU), labelsValues...)
-		ch <- c.numCoresPerSocket.mustNewConstMetric(float64(item.Config.Hardware.NumCoresPerSocket), labelsValues...)
-		ch <- c.memoryBytes.mustNewConstMetric(float64(int64(item.Config.Hardware.MemoryMB)*mb), labelsValues...)
+		if item.Config != nil {
+			ch <- c.numCPU.mustNewConstMetric(float64(item.Config.Hardware.NumCPU), labelsValues...)
+			ch <- c.numCoresPerSocket.mustNewConstMetric(float64(item.Config.Hardware.NumCoresPerSocket), labelsValues...)
+			ch <- c.memoryBytes.mustNewConstMetric(float64(int64(item.Config.Hardware.MemoryMB)*mb), labelsValues...)
+		}
 		ch <- c.overallCPUUsage.mustNewConstMetric(float64(item.Summary.QuickStats.OverallCpuUsage), labelsValues...)
 		ch <- c.overallCPUDemand.mustNewConstMetric(float64(item.Summary.QuickStats.OverallCpuDemand), labelsValues...)
 		ch <- c.guestMemoryUsage.mustNewConstMetric(float64(int64(item.Summary.QuickStats.GuestMemoryUsage)*mb), labelsValues...)
@@ -284,10 +371,16 @@ func (c *virtualMachineCollector) Update(ch chan<- prometheus.Metric) (err error
 		ch <- c.uptimeSeconds.mustNewConstMetric(float64(item.Summary.QuickStats.UptimeSeconds), labelsValues...)
 		ch <- c.ssdSwappedMemory.mustNewConstMetric(float64(int64(item.Summary.QuickStats.SsdSwappedMemory)*mb), labelsValues...)
 
-		if item.Snapshot != nil {
-			ch <- c.numSnapshot.mustNewConstMetric(float64(len(item.Snapshot.RootSnapshotList)), labelsValues...)
-		} else {
-			ch <- c.numSnapshot.mustNewConstMetric(0.0, labelsValues...)
+		if item.Snapshot != nil || c.hasProperty("snapshot") {
+			snapshots := GetSnapshots(item)
+			ch <- c.numSnapshot.mustNewConstMetric(float64(len(snapshots)), labelsValues...)
+			for _, snap := range snapshots {
+				tmp := append(labelsValues, snap.name, snap.id, strconv.FormatBool(snap.memory), strconv.FormatBool(snap.quiesced), strconv.Itoa(snap.depth))
+				ch <- c.snapshotCreateTime.mustNewConstMetric(float64(snap.created.Unix()), tmp...)
+			}
+		}
+		if item.LayoutEx != nil {
+			ch <- c.snapshotDeltaBytes.mustNewConstMetric(float64(GetSnapshotDeltaBytes(item)), labelsValues...)
 		}
 
 		edevices := GetEthernetDevices(item)
@@ -304,8 +397,35 @@ func (c *virtualMachineCollector) Update(ch chan<- prometheus.Metric) (err error
 		}
 		disks := GetDisks(item)
 		for _, disk := range disks {
-			tmp := append(labelsValues, disk.vmdk)
+			tmp := append(labelsValues,
+				disk.vmdk, disk.backing, strconv.FormatBool(disk.thin), disk.mode,
+				disk.controller, disk.unit, disk.datastore, disk.sharing,
+			)
 			ch <- c.diskCapacityBytes.mustNewConstMetric(float64(disk.capacity), tmp...)
+			if disk.committed >= 0 {
+				ch <- c.diskCommittedBytes.mustNewConstMetric(float64(disk.committed), tmp...)
+			}
+		}
+		if storage := item.Summary.Storage; storage != nil {
+			ch <- c.committedBytes.mustNewConstMetric(float64(storage.Committed), labelsValues...)
+			ch <- c.uncommittedBytes.mustNewConstMetric(float64(storage.Uncommitted), labelsValues...)
+			ch <- c.unsharedBytes.mustNewConstMetric(float64(storage.Unshared), labelsValues...)
+		}
+		if item.Storage != nil {
+			for _, usage := range item.Storage.PerDatastoreUsage {
+				tmp := append(labelsValues, scrape.hierarchy.Name(&usage.Datastore))
+				ch <- c.datastoreCommittedBytes.mustNewConstMetric(float64(usage.Committed), tmp...)
+				ch <- c.datastoreUncommittedBytes.mustNewConstMetric(float64(usage.Uncommitted), tmp...)
+				ch <- c.datastoreUnsharedBytes.mustNewConstMetric(float64(usage.Unshared), tmp...)
+			}
+		}
+		for _, disk := range GetGuestDisks(item) {
+			if !c.mounts.Match(disk.path) {
+				continue
+			}
+			tmp := append(labelsValues, disk.path, disk.filesystem)
+			ch <- c.guestDiskCapacityBytes.mustNewConstMetric(float64(disk.capacity), tmp...)
+			ch <- c.guestDiskFreeBytes.mustNewConstMetric(float64(disk.free), tmp...)
 		}
 	}
 	return nil
@@ -323,7 +443,9 @@ func GetIsecAnnotation(vm mo.VirtualMachine) IsecAnnotation {
 		Responsable: "not defined",
 		Criticality: "not defined",
 	}
-	_ = json.Unmarshal([]byte(vm.Config.Annotation), &tmp)
+	if vm.Config != nil {
+		_ = json.Unmarshal([]byte(vm.Config.Annotation), &tmp)
+	}
 	return tmp
 }
 
@@ -336,6 +458,9 @@ type EthernetDevice struct {
 }
 
 func GetEthernetDevices(vm mo.VirtualMachine) []EthernetDevice {
+	if vm.Config == nil {
+		return nil
+	}
 	devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
 	res := make([]EthernetDevice, 0, len(devices))
 	for _, dev := range devices {
@@ -373,6 +498,9 @@ type Network struct {
 }
 
 func GetNetworks(vm mo.VirtualMachine) []Network {
+	if vm.Guest == nil {
+		return nil
+	}
 	res := make([]Network, 0, len(vm.Guest.Net))
 	for _, net := range vm.Guest.Net {
 		item := Network{
@@ -386,54 +514,222 @@ func GetNetworks(vm mo.VirtualMachine) []Network {
 	return res
 }
 
+type GuestDisk struct {
+	path       string
+	filesystem string
+	capacity   int64
+	free       int64
+}
+
+func GetGuestDisks(vm mo.VirtualMachine) []GuestDisk {
+	if vm.Guest == nil {
+		return nil
+	}
+	res := make([]GuestDisk, 0, len(vm.Guest.Disk))
+	for _, disk := range vm.Guest.Disk {
+		filesystem := disk.FilesystemType
+		if filesystem == "" {
+			filesystem = "NONE"
+		}
+		res = append(res, GuestDisk{
+			path:       disk.DiskPath,
+			filesystem: filesystem,
+			capacity:   disk.Capacity,
+			free:       disk.FreeSpace,
+		})
+	}
+	return res
+}
+
 type Disk struct {
-	vmdk     string
-	capacity int64
+	key        int32
+	vmdk       string
+	backing    string
+	thin       bool
+	mode       string
+	controller string
+	unit       string
+	datastore  string
+	sharing    string
+	capacity   int64
+	// committed is the size of the files of the disk chain, -1 without
+	// layoutEx.
+	committed int64
 }
 
+// GetDisks returns the virtual disks of the vm, whatever their backing.
 func GetDisks(vm mo.VirtualMachine) []Disk {
+	if vm.Config == nil {
+		return nil
+	}
 	disks := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
 	res := make([]Disk, 0, len(disks))
 	for _, d := range disks {
 		disk := d.(*types.VirtualDisk)
-		info := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
-		res = append(res, Disk{
-			vmdk:     info.FileName,
-			capacity: disk.CapacityInBytes,
-		})
+		item := Disk{
+			key:        disk.Key,
+			vmdk:       "NONE",
+			backing:    "NONE",
+			mode:       "NONE",
+			controller: strconv.Itoa(int(disk.ControllerKey)),
+			unit:       "NONE",
+			datastore:  "NONE",
+			sharing:    "NONE",
+			capacity:   disk.CapacityInBytes,
+			committed:  -1,
+		}
+		if disk.UnitNumber != nil {
+			item.unit = strconv.Itoa(int(*disk.UnitNumber))
+		}
+		if disk.Backing != nil {
+			item.backing = strings.TrimSuffix(strings.TrimPrefix(
+				reflect.TypeOf(disk.Backing).Elem().Name(), "VirtualDisk"), "BackingInfo")
+		}
+		if b, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+			item.vmdk = b.GetVirtualDeviceFileBackingInfo().FileName
+			var path object.DatastorePath
+			if path.FromString(item.vmdk) {
+				item.datastore = path.Datastore
+			}
+		}
+		switch b := disk.Backing.(type) {
+		case *types.VirtualDiskFlatVer1BackingInfo:
+			item.mode = b.DiskMode
+		case *types.VirtualDiskFlatVer2BackingInfo:
+			item.mode = b.DiskMode
+			item.thin = b.ThinProvisioned != nil && *b.ThinProvisioned
+			item.sharing = b.Sharing
+		case *types.VirtualDiskSparseVer1BackingInfo:
+			item.mode = b.DiskMode
+			item.thin = true
+		case *types.VirtualDiskSparseVer2BackingInfo:
+			item.mode = b.DiskMode
+			item.thin = true
+		case *types.VirtualDiskSeSparseBackingInfo:
+			item.mode = b.DiskMode
+			item.thin = true
+		case *types.VirtualDiskRawDiskMappingVer1BackingInfo:
+			item.mode = b.DiskMode
+			item.sharing = b.Sharing
+		case *types.VirtualDiskLocalPMemBackingInfo:
+			item.mode = b.DiskMode
+		}
+		if item.mode == "" {
+			item.mode = "NONE"
+		}
+		if item.sharing == "" {
+			item.sharing = "NONE"
+		}
+		if vm.LayoutEx != nil {
+			item.committed = diskCommittedBytes(vm.LayoutEx, disk.Key)
+		}
+		res = append(res, item)
 	}
 	return res
 }
 
-func (c *virtualMachineCollector) apiRetrieve() ([]mo.VirtualMachine, error) {
-	var items []mo.VirtualMachine
+// diskCommittedBytes returns the size of the files of the current chain of
+// the disk key, its base disk and deltas.
+func diskCommittedBytes(layout *types.VirtualMachineFileLayoutEx, key int32) int64 {
+	files := make(map[int32]bool)
+	for _, disk := range layout.Disk {
+		if disk.Key != key {
+			continue
+		}
+		for _, unit := range disk.Chain {
+			for _, k := range unit.FileKey {
+				files[k] = true
+			}
+		}
+	}
+	var res int64
+	for _, file := range layout.File {
+		if files[file.Key] {
+			res += file.Size
+		}
+	}
+	return res
+}
 
-	m := view.NewManager(c.client.Client)
-	v, err := m.CreateContainerView(
-		c.ctx,
-		c.client.ServiceContent.RootFolder,
-		[]string{"VirtualMachine"},
-		true,
-	)
-	if err != nil {
-		return items, err
+type Snapshot struct {
+	name string
+	// id is the snapshot moref value, two snapshots of a vm may have the
+	// same name.
+	id       string
+	created  time.Time
+	memory   bool
+	quiesced bool
+	// depth is 1 for the root snapshots.
+	depth int
+}
+
+// GetSnapshots returns the snapshots of the vm tree, parents first.
+func GetSnapshots(vm mo.VirtualMachine) []Snapshot {
+	if vm.Snapshot == nil {
+		return nil
+	}
+	var res []Snapshot
+	var walk func(trees []types.VirtualMachineSnapshotTree, depth int)
+	walk = func(trees []types.VirtualMachineSnapshotTree, depth int) {
+		for _, tree := range trees {
+			res = append(res, Snapshot{
+				name:     tree.Name,
+				id:       tree.Snapshot.Value,
+				created:  tree.CreateTime,
+				memory:   tree.State == types.VirtualMachinePowerStatePoweredOn,
+				quiesced: tree.Quiesced,
+				depth:    depth,
+			})
+			walk(tree.ChildSnapshotList, depth+1)
+		}
+	}
+	walk(vm.Snapshot.RootSnapshotList, 1)
+	return res
+}
+
+// GetSnapshotDeltaBytes returns the size of the delta disk files of the vm,
+// the files of the disk chains but their base disk, of the current state and
+// of every snapshot.
+func GetSnapshotDeltaBytes(vm mo.VirtualMachine) int64 {
+	if vm.LayoutEx == nil {
+		return 0
+	}
+	deltas := make(map[int32]bool)
+	addDeltas := func(chain []types.VirtualMachineFileLayoutExDiskUnit) {
+		for i := 1; i < len(chain); i++ {
+			for _, key := range chain[i].FileKey {
+				deltas[key] = true
+			}
+		}
+	}
+	for _, disk := range vm.LayoutEx.Disk {
+		addDeltas(disk.Chain)
 	}
-	defer c.destroyView(v)
+	for _, snap := range vm.LayoutEx.Snapshot {
+		for _, disk := range snap.Disk {
+			addDeltas(disk.Chain)
+		}
+	}
+	var res int64
+	for _, file := range vm.LayoutEx.File {
+		if deltas[file.Key] {
+			res += file.Size
+		}
+	}
+	return res
+}
+
+func (c *virtualMachineCollector) apiRetrieve(scrape *Scrape) ([]mo.VirtualMachine, error) {
+	var items []mo.VirtualMachine
 
-	err = v.Retrieve(
-		c.ctx,
-		[]string{"VirtualMachine"},
+	err := c.retrieve(
+		scrape,
+		"VirtualMachine",
 		[]string{
-			"config",
 			//"datatore",
-			"guest",
-			"guestHeartbeatStatus",
-			"network",
 			"parent",
-			"resourceConfig",
 			"resourcePool",
 			"runtime",
-			"snapshot",
 			"summary",
 		},
 		&items,
@@ -0,0 +1,145 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noesx
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestGetSnapshots(t *testing.T) {
+	vm := mo.VirtualMachine{Snapshot: &types.VirtualMachineSnapshotInfo{
+		RootSnapshotList: []types.VirtualMachineSnapshotTree{{
+			Snapshot: types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "snapshot-1"},
+			Name:     "base",
+			State:    types.VirtualMachinePowerStatePoweredOff,
+			ChildSnapshotList: []types.VirtualMachineSnapshotTree{{
+				Snapshot: types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "snapshot-2"},
+				Name:     "upgrade",
+				State:    types.VirtualMachinePowerStatePoweredOn,
+				Quiesced: true,
+			}},
+		}},
+	}}
+	snapshots := GetSnapshots(vm)
+	if len(snapshots) != 2 {
+		t.Fatalf("want 2 snapshots, have %v", snapshots)
+	}
+	if s := snapshots[0]; s.name != "base" || s.id != "snapshot-1" || s.depth != 1 || s.memory {
+		t.Errorf("unexpected root snapshot %+v", s)
+	}
+	if s := snapshots[1]; s.name != "upgrade" || s.id != "snapshot-2" || s.depth != 2 || !s.memory || !s.quiesced {
+		t.Errorf("unexpected child snapshot %+v", s)
+	}
+}
+
+func TestGetSnapshotDeltaBytes(t *testing.T) {
+	chain := func(keys ...int32) []types.VirtualMachineFileLayoutExDiskUnit {
+		res := make([]types.VirtualMachineFileLayoutExDiskUnit, 0, len(keys))
+		for _, key := range keys {
+			res = append(res, types.VirtualMachineFileLayoutExDiskUnit{FileKey: []int32{key}})
+		}
+		return res
+	}
+	vm := mo.VirtualMachine{LayoutEx: &types.VirtualMachineFileLayoutEx{
+		File: []types.VirtualMachineFileLayoutExFileInfo{
+			{Key: 1, Size: 1000},
+			{Key: 2, Size: 20},
+			{Key: 3, Size: 300},
+		},
+		Disk: []types.VirtualMachineFileLayoutExDiskLayout{{Key: 2000, Chain: chain(1, 2, 3)}},
+		Snapshot: []types.VirtualMachineFileLayoutExSnapshotLayout{{
+			Disk: []types.VirtualMachineFileLayoutExDiskLayout{{Key: 2000, Chain: chain(1, 2)}},
+		}},
+	}}
+	if have := GetSnapshotDeltaBytes(vm); have != 320 {
+		t.Errorf("want 320 delta bytes, have %d", have)
+	}
+}
+
+func TestGetDisks(t *testing.T) {
+	unit := int32(1)
+	thin := true
+	vm := mo.VirtualMachine{
+		Config: &types.VirtualMachineConfigInfo{Hardware: types.VirtualHardware{Device: []types.BaseVirtualDevice{
+			&types.VirtualDisk{
+				VirtualDevice: types.VirtualDevice{
+					Key: 2000, ControllerKey: 1000, UnitNumber: &unit,
+					Backing: &types.VirtualDiskFlatVer2BackingInfo{
+						VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[ds1] web/web.vmdk"},
+						DiskMode:                     "persistent",
+						ThinProvisioned:              &thin,
+					},
+				},
+				CapacityInBytes: 4096,
+			},
+			&types.VirtualDisk{
+				VirtualDevice: types.VirtualDevice{
+					Key: 2001, ControllerKey: 1000,
+					Backing: &types.VirtualDiskRawDiskMappingVer1BackingInfo{
+						VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[ds2] web/web_1.vmdk"},
+						Sharing:                      "sharingMultiWriter",
+					},
+				},
+			},
+			&types.VirtualDisk{
+				VirtualDevice: types.VirtualDevice{
+					Key: 2002, ControllerKey: 1000,
+					Backing: &types.VirtualDiskPartitionedRawDiskVer2BackingInfo{},
+				},
+			},
+		}}},
+		LayoutEx: &types.VirtualMachineFileLayoutEx{
+			File: []types.VirtualMachineFileLayoutExFileInfo{{Key: 1, Size: 100}, {Key: 2, Size: 2000}},
+			Disk: []types.VirtualMachineFileLayoutExDiskLayout{{
+				Key:   2000,
+				Chain: []types.VirtualMachineFileLayoutExDiskUnit{{FileKey: []int32{1, 2}}},
+			}},
+		},
+	}
+	disks := GetDisks(vm)
+	if len(disks) != 3 {
+		t.Fatalf("want 3 disks, have %v", disks)
+	}
+	if d := disks[0]; d.backing != "FlatVer2" || !d.thin || d.mode != "persistent" || d.unit != "1" || d.datastore != "ds1" || d.committed != 2100 {
+		t.Errorf("unexpected flat disk %+v", d)
+	}
+	if d := disks[1]; d.backing != "RawDiskMappingVer1" || d.sharing != "sharingMultiWriter" || d.datastore != "ds2" || d.committed != 0 {
+		t.Errorf("unexpected rdm disk %+v", d)
+	}
+	if d := disks[2]; d.backing != "PartitionedRawDiskVer2" || d.vmdk != "NONE" || d.datastore != "NONE" {
+		t.Errorf("unexpected raw disk %+v", d)
+	}
+}
+
+func TestVirtualMachineOptions(t *testing.T) {
+	c, err := LoadConfig("testdata/config.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts := c.Collectors["vm"].Options.(*virtualMachineOptions).Mounts
+	for path, want := range map[string]bool{
+		"/var": true,
+		`C:\`:  true,
+		`\\?\Volume{0b3b8f2e-0000-0000-0000-100000000000}\`: false,
+	} {
+		if have := mounts.Match(path); have != want {
+			t.Errorf("%s: want match %v, have %v", path, want, have)
+		}
+	}
+}
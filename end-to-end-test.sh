@@ -4,6 +4,9 @@ set -euf -o pipefail
 
 enabled_collectors=$(
   cat <<COLLECTORS
+alarm
+cluster
+vcenter
 COLLECTORS
 )
 disabled_collectors=$(
@@ -73,6 +76,7 @@ echo $! >"${tmpdir}/vcsim.pid"
 ./govc_exporter \
   $(for c in ${enabled_collectors}; do echo --collector.${c}; done) \
   $(for c in ${disabled_collectors}; do echo --no-collector.${c}; done) \
+  --collector.vc.tls.insecure-skip-verify \
   --web.listen-address "127.0.0.1:${port}" \
   --log.level="debug" >"${tmpdir}/govc_exporter.log" 2>&1 &
 
@@ -15,10 +15,15 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
@@ -35,22 +40,39 @@ import (
 
 // handler wraps an unfiltered http.Handler but uses a filtered handler,
 // created on the fly, if filtering is requested. Create instances with
-// newHandler.
+// newHandler, then set the vc with update.
 type handler struct {
-	unfilteredHandler http.Handler
 	// exporterMetricsRegistry is a separate registry for the metrics about
 	// the exporter itself.
 	exporterMetricsRegistry *prometheus.Registry
 	includeExporterMetrics  bool
 	maxRequests             int
-	logger                  log.Logger
+	// refreshInterval enables the background collection of the unfiltered
+	// metrics when not 0, scrapes are then served from the last snapshot.
+	refreshInterval time.Duration
+	snapshotMaxAge  time.Duration
+	logger          log.Logger
+
+	mux               sync.RWMutex
+	unfilteredHandler http.Handler
+	snapshot          *collector.SnapshotCollector
+	// session is the vc served on /metrics, nil when the exporter is only
+	// used through /probe.
+	session *collector.Session
+	// module selects the collectors of the unfiltered handler when /metrics
+	// serves the metrics_target of the config file, nil otherwise.
+	module *collector.Module
+	// config holds the collector options.
+	config *collector.Config
 }
 
-func newHandler(includeExporterMetrics bool, maxRequests int, logger log.Logger) *handler {
+func newHandler(includeExporterMetrics bool, maxRequests int, refreshInterval time.Duration, snapshotMaxAge time.Duration, logger log.Logger) *handler {
 	h := &handler{
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporterMetrics,
 		maxRequests:             maxRequests,
+		refreshInterval:         refreshInterval,
+		snapshotMaxAge:          snapshotMaxAge,
 		logger:                  logger,
 	}
 	if h.includeExporterMetrics {
@@ -59,26 +81,46 @@ func newHandler(includeExporterMetrics bool, maxRequests int, logger log.Logger)
 			prometheus.NewGoCollector(),
 		)
 	}
-	if innerHandler, err := h.innerHandler(); err != nil {
-		panic(fmt.Sprintf("Couldn't create metrics handler: %s", err))
-	} else {
-		h.unfilteredHandler = innerHandler
-	}
 	return h
 }
 
+// update rebuilds the unfiltered handler for the given vc and config and
+// replaces the current one, scrapes in flight complete with the previous
+// collectors. The current handler is kept on error.
+func (h *handler) update(session *collector.Session, module *collector.Module, config *collector.Config) error {
+	innerHandler, snapshot, err := h.innerHandler(session, module, config)
+	if err != nil {
+		return err
+	}
+	h.mux.Lock()
+	previous := h.snapshot
+	h.unfilteredHandler = innerHandler
+	h.snapshot = snapshot
+	h.session = session
+	h.module = module
+	h.config = config
+	h.mux.Unlock()
+	if previous != nil {
+		previous.Stop()
+	}
+	return nil
+}
+
 // ServeHTTP implements http.Handler.
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filters := r.URL.Query()["collect[]"]
 	level.Debug(h.logger).Log("msg", "collect query:", "filters", filters)
 
+	h.mux.RLock()
+	unfilteredHandler, session, config := h.unfilteredHandler, h.session, h.config
+	h.mux.RUnlock()
 	if len(filters) == 0 {
 		// No filters, use the prepared unfiltered handler.
-		h.unfilteredHandler.ServeHTTP(w, r)
+		unfilteredHandler.ServeHTTP(w, r)
 		return
 	}
 	// To serve filtered metrics, we create a filtering handler on the fly.
-	filteredHandler, err := h.innerHandler(filters...)
+	filteredHandler, _, err := h.innerHandler(session, nil, config, filters...)
 	if err != nil {
 		level.Warn(h.logger).Log("msg", "Couldn't create filtered metrics handler:", "err", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -90,33 +132,44 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // innerHandler is used to create both the one unfiltered http.Handler to be
 // wrapped by the outer handler and also the filtered handlers created on the
-// fly. The former is accomplished by calling innerHandler without any arguments
+// fly. The former is accomplished by calling innerHandler without any filters
 // (in which case it will log all the collectors enabled via command-line
-// flags).
-func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
-	nc, err := collector.NewMainCollector(h.logger, filters...)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't create collector: %s", err)
-	}
+// flags or the module), and returns the started background collector, if any.
+func (h *handler) innerHandler(session *collector.Session, module *collector.Module, config *collector.Config, filters ...string) (http.Handler, *collector.SnapshotCollector, error) {
+	r := prometheus.NewRegistry()
+	r.MustRegister(version.NewCollector("node_exporter"))
 
-	// Only log the creation of an unfiltered handler, which should happen
-	// only once upon startup.
-	if len(filters) == 0 {
-		level.Info(h.logger).Log("msg", "Enabled collectors")
-		collectors := []string{}
-		for n := range nc.Collectors {
-			collectors = append(collectors, n)
+	var sc *collector.SnapshotCollector
+	if session != nil {
+		var nc *collector.MainCollector
+		var err error
+		if module != nil && len(filters) == 0 {
+			nc, err = collector.NewModuleCollector(h.logger, session, config, module)
+		} else {
+			nc, err = collector.NewMainCollector(h.logger, session, config, filters...)
 		}
-		sort.Strings(collectors)
-		for _, c := range collectors {
-			level.Info(h.logger).Log("collector", c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't create collector: %s", err)
 		}
-	}
 
-	r := prometheus.NewRegistry()
-	r.MustRegister(version.NewCollector("node_exporter"))
-	if err := r.Register(nc); err != nil {
-		return nil, fmt.Errorf("couldn't register node collector: %s", err)
+		// Only log the creation of an unfiltered handler, which should happen
+		// only upon startup and reload.
+		if len(filters) == 0 {
+			logCollectors(h.logger, nc)
+		}
+
+		var c prometheus.Collector = nc
+		if len(filters) == 0 && h.refreshInterval > 0 {
+			level.Info(h.logger).Log("msg", "Collecting in background", "interval", h.refreshInterval)
+			sc = collector.NewSnapshotCollector(h.logger, nc, h.refreshInterval, h.snapshotMaxAge)
+			c = sc
+		}
+		if err := r.Register(c); err != nil {
+			return nil, nil, fmt.Errorf("couldn't register node collector: %s", err)
+		}
+		if sc != nil {
+			sc.Start()
+		}
 	}
 	handler := promhttp.HandlerFor(
 		prometheus.Gatherers{h.exporterMetricsRegistry, r},
@@ -133,7 +186,117 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 			h.exporterMetricsRegistry, handler,
 		)
 	}
-	return handler, nil
+	return handler, sc, nil
+}
+
+func logCollectors(logger log.Logger, nc *collector.MainCollector) {
+	level.Info(logger).Log("msg", "Enabled collectors")
+	collectors := []string{}
+	for n := range nc.Collectors {
+		collectors = append(collectors, n)
+	}
+	sort.Strings(collectors)
+	for _, c := range collectors {
+		level.Info(logger).Log("collector", c)
+	}
+}
+
+// probeHandler scrapes the vc given by the target parameter with the
+// credentials and collectors of the requested module. A target defined in
+// the config file is probed with its own url and module, other targets are
+// rejected unless allowAnyTarget is set, as the module credentials are sent
+// to them.
+type probeHandler struct {
+	mux            sync.RWMutex
+	config         *collector.Config
+	allowAnyTarget bool
+	logger         log.Logger
+}
+
+func (h *probeHandler) setConfig(config *collector.Config) {
+	h.mux.Lock()
+	h.config = config
+	h.mux.Unlock()
+}
+
+// targets returns the sorted names of the targets of the config file.
+func (h *probeHandler) targets() []string {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	if h.config == nil {
+		return nil
+	}
+	res := make([]string, 0, len(h.config.Targets))
+	for name := range h.config.Targets {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ServeHTTP implements http.Handler.
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	h.mux.RLock()
+	config := h.config
+	h.mux.RUnlock()
+	url := target
+	moduleName := r.URL.Query().Get("module")
+	if t, ok := config.Targets[target]; ok {
+		url = t.URL
+		if moduleName == "" {
+			moduleName = t.Module
+		}
+	} else if !h.allowAnyTarget {
+		http.Error(w, fmt.Sprintf("Unknown target %q", target), http.StatusBadRequest)
+		return
+	}
+	if moduleName == "" {
+		moduleName = "default"
+	}
+	module, ok := config.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	logger := log.With(h.logger, "target", target, "module", moduleName)
+	level.Debug(logger).Log("msg", "probe query")
+
+	session := collector.GetSession(h.logger, url, module.Credentials(), module.TLSConfig)
+	nc, err := collector.NewModuleCollector(logger, session, config, &module)
+	if err != nil {
+		level.Warn(logger).Log("msg", "Couldn't create probe collector:", "err", err)
+		http.Error(w, fmt.Sprintf("Couldn't create probe collector: %s", err), http.StatusInternalServerError)
+		return
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(nc); err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't register probe collector: %s", err), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+}
+
+// landingPage returns the home page, linking to the metrics and to the
+// probe of each target.
+func landingPage(metricsPath string, targets []string) []byte {
+	var b strings.Builder
+	b.WriteString(`<html>
+			<head><title>govc Exporter</title></head>
+			<body>
+			<h1>govc Exporter</h1>
+			<p><a href="` + html.EscapeString(metricsPath) + `">Metrics</a></p>
+`)
+	for _, target := range targets {
+		fmt.Fprintf(&b, "\t\t\t<p><a href=\"/probe?target=%s\">Probe %s</a></p>\n", html.EscapeString(url.QueryEscape(target)), html.EscapeString(target))
+	}
+	b.WriteString(`			</body>
+			</html>`)
+	return []byte(b.String())
 }
 
 func main() {
@@ -162,6 +325,30 @@ func main() {
 			"web.config",
 			"[EXPERIMENTAL] Path to config yaml file that can enable TLS or authentication.",
 		).Default("").String()
+		refreshInterval = kingpin.Flag(
+			"collector.refresh-interval",
+			"Run the collectors in background at this interval and serve the last snapshot on scrape. Use 0 to collect on every scrape.",
+		).Default("0s").Duration()
+		snapshotMaxAge = kingpin.Flag(
+			"collector.snapshot-max-age",
+			"Drop the background snapshot when older than this duration. Use 0 to never drop it.",
+		).Default("5m").Duration()
+		exporterConfigFile = kingpin.Flag(
+			"config.file",
+			"Path to the exporter config yaml file defining the targets, modules and collector options.",
+		).Default("").String()
+		checkConfig = kingpin.Flag(
+			"config.check",
+			"Validate the exporter config file and exit.",
+		).Default("false").Bool()
+		enableLifecycle = kingpin.Flag(
+			"web.enable-lifecycle",
+			"Enable the POST /-/reload endpoint, protected by the basic_auth_users of --web.config.",
+		).Default("false").Bool()
+		allowAnyTarget = kingpin.Flag(
+			"probe.allow-any-target",
+			"Probe targets missing from the targets of the config file, sending them the module credentials.",
+		).Default("false").Bool()
 	)
 
 	promlogConfig := &promlog.Config{}
@@ -174,18 +361,48 @@ func main() {
 	if *disableDefaultCollectors {
 		collector.DisableDefaultCollectors()
 	}
+	if *checkConfig {
+		if *exporterConfigFile == "" {
+			fmt.Fprintln(os.Stderr, "--config.check requires --config.file")
+			os.Exit(1)
+		}
+		if _, err := collector.LoadConfig(*exporterConfigFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *exporterConfigFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", *exporterConfigFile)
+		os.Exit(0)
+	}
 	level.Info(logger).Log("msg", "Starting govc_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 
-	http.Handle(*metricsPath, newHandler(!*disableExporterMetrics, *maxRequests, logger))
+	if collector.DefaultSession(logger) == nil && *exporterConfigFile == "" {
+		level.Error(logger).Log("msg", "--collector.vc.url is required unless --config.file is set")
+		os.Exit(1)
+	}
+	metricsHandler := newHandler(!*disableExporterMetrics, *maxRequests, *refreshInterval, *snapshotMaxAge, logger)
+	probe := &probeHandler{allowAnyTarget: *allowAnyTarget, logger: logger}
+	reloader := newReloader(*exporterConfigFile, metricsHandler, probe, logger)
+	if err := reloader.reload(); err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "file", *exporterConfigFile, "err", err)
+		os.Exit(1)
+	}
+	metricsHandler.exporterMetricsRegistry.MustRegister(reloader)
+	go reloader.watchSignals()
+
+	if *exporterConfigFile != "" {
+		http.Handle("/probe", probe)
+	}
+	if *enableLifecycle {
+		http.Handle("/-/reload", reloader)
+	}
+	http.Handle(*metricsPath, metricsHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>govc Exporter</title></head>
-			<body>
-			<h1>govc Exporter</h1>
-			<p><a href="` + *metricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
+		var targets []string
+		if *exporterConfigFile != "" {
+			targets = probe.targets()
+		}
+		w.Write(landingPage(*metricsPath, targets))
 	})
 
 	level.Info(logger).Log("msg", "Listening on", "address", *listenAddress)
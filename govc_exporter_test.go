@@ -17,12 +17,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/intrinsec/govc_exporter/collector"
 	"github.com/prometheus/procfs"
 )
 
@@ -94,6 +98,36 @@ func TestHandlingOfDuplicatedMetrics(t *testing.T) {
 	}
 }
 
+func TestProbeRejectsUnknownTarget(t *testing.T) {
+	config, err := collector.LoadConfig("collector/testdata/config.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &probeHandler{config: config, logger: log.NewNopLogger()}
+	w := httptest.NewRecorder()
+	probe.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?target=https://vc.example.com/sdk&module=default", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want status %d for a target missing from the config, have %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestLandingPageTargets(t *testing.T) {
+	config, err := collector.LoadConfig("collector/testdata/config.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &probeHandler{config: config, logger: log.NewNopLogger()}
+	page := string(landingPage("/metrics", probe.targets()))
+	for _, link := range []string{`href="/metrics"`, `href="/probe?target=lyon"`, `href="/probe?target=paris"`} {
+		if !strings.Contains(page, link) {
+			t.Errorf("want link %s in the landing page:\n%s", link, page)
+		}
+	}
+	if page := string(landingPage("/metrics", nil)); strings.Contains(page, "/probe") {
+		t.Errorf("want no probe link without targets:\n%s", page)
+	}
+}
+
 func queryExporter(address string) error {
 	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", address))
 	if err != nil {
@@ -0,0 +1,160 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/intrinsec/govc_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloadSuccessDesc = prometheus.NewDesc(
+		"govc_exporter_config_last_reload_successful",
+		"Whether the last configuration reload attempt was successful.",
+		nil, nil,
+	)
+	configReloadSecondsDesc = prometheus.NewDesc(
+		"govc_exporter_config_last_reload_success_timestamp_seconds",
+		"Timestamp of the last successful configuration reload.",
+		nil, nil,
+	)
+)
+
+// reloader re-reads the exporter config file and swaps the collectors of the
+// /metrics and /probe handlers. A failed reload keeps the previous
+// configuration.
+type reloader struct {
+	configFile string
+	metrics    *handler
+	probe      *probeHandler
+	logger     log.Logger
+
+	// reloadMux serializes the reloads, mux only guards their outcome so
+	// that Collect never waits for a reload, e.g. a login to a slow vc.
+	reloadMux   sync.Mutex
+	mux         sync.Mutex
+	lastSuccess bool
+	lastLoad    time.Time
+}
+
+func newReloader(configFile string, metrics *handler, probe *probeHandler, logger log.Logger) *reloader {
+	return &reloader{
+		configFile: configFile,
+		metrics:    metrics,
+		probe:      probe,
+		logger:     logger,
+	}
+}
+
+func (r *reloader) reload() error {
+	r.reloadMux.Lock()
+	defer r.reloadMux.Unlock()
+	err := r.apply()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.lastSuccess = err == nil
+	if err == nil {
+		r.lastLoad = time.Now()
+	}
+	return err
+}
+
+// apply loads the config file and the sessions it serves, then builds the
+// collectors of /metrics. The handlers are only updated once all of these
+// succeeded.
+func (r *reloader) apply() error {
+	config := &collector.Config{}
+	if r.configFile != "" {
+		var err error
+		config, err = collector.LoadConfig(r.configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	session := collector.DefaultSession(r.logger)
+	var module *collector.Module
+	if session == nil && config.MetricsTarget != "" {
+		var err error
+		session, module, err = config.TargetSession(r.logger, config.MetricsTarget)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.metrics.update(session, module, config); err != nil {
+		return err
+	}
+	r.probe.setConfig(config)
+	collector.RetainSessions(context.Background(), config, session)
+	return nil
+}
+
+// watchSignals reloads the configuration on SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		r.reloadAndLog()
+	}
+}
+
+func (r *reloader) reloadAndLog() error {
+	if err := r.reload(); err != nil {
+		level.Error(r.logger).Log("msg", "Error reloading config", "file", r.configFile, "err", err)
+		return err
+	}
+	level.Info(r.logger).Log("msg", "Completed loading of configuration file", "file", r.configFile)
+	return nil
+}
+
+// ServeHTTP implements http.Handler for POST /-/reload.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reloadAndLog(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- configReloadSuccessDesc
+	ch <- configReloadSecondsDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var success float64
+	if r.lastSuccess {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(configReloadSuccessDesc, prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(configReloadSecondsDesc, prometheus.GaugeValue, float64(r.lastLoad.Unix()))
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Intrinsec
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectDuringReload(t *testing.T) {
+	logger := log.NewNopLogger()
+	r := newReloader("", newHandler(false, 0, 0, 0, logger), &probeHandler{logger: logger}, logger)
+	r.reloadMux.Lock()
+	defer r.reloadMux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ch := make(chan prometheus.Metric, 2)
+		r.Collect(ch)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collect blocked by an ongoing reload")
+	}
+}
+
+func TestReloadKeepsPreviousConfigOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yml")
+	good, err := ioutil.ReadFile("collector/testdata/config.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configFile, good, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.NewNopLogger()
+	metrics := newHandler(false, 0, 0, 0, logger)
+	probe := &probeHandler{logger: logger}
+	r := newReloader(configFile, metrics, probe, logger)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.session == nil || metrics.module == nil {
+		t.Fatal("metrics_target not served on /metrics")
+	}
+	config := probe.config
+	if metrics.config != config {
+		t.Fatal("/metrics and /probe built with different configs")
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte("junk: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want status %d on invalid config, have %d", http.StatusInternalServerError, w.Code)
+	}
+	if r.lastSuccess {
+		t.Error("failed reload reported as successful")
+	}
+	if probe.config != config {
+		t.Error("failed reload replaced the probe config")
+	}
+	if metrics.config != config {
+		t.Error("failed reload replaced the metrics config")
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("want status %d on GET, have %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}